@@ -0,0 +1,402 @@
+package ksema
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+// Format of the backup file
+const (
+	BACKUP_FORMAT  = "ksema-backup"
+	BACKUP_VERSION = 1
+	// Maximum size of a backup file
+	MAX_BACKUP_SIZE = 1024 * 1024
+)
+
+var (
+	// ErrInvalidBackup is returned when the backup file is corrupt, truncated or not supported
+	ErrInvalidBackup = errors.New("invalid backup file")
+	// ErrMalformedBackup is returned when the backup returned by the server is truncated or has trailing data
+	ErrMalformedBackup = errors.New("malformed backup response")
+)
+
+// BackupBlob is the key exported by the server
+type BackupBlob struct {
+	// Header returned by the server, e.g. "KSEMA;SYM;AES01"
+	Header string
+	// Key type and label parsed from the header, empty if the header has another format
+	KeyType string
+	Label   string
+	// User type of the client performing the backup
+	UserType int
+	// Time of the backup and address of the server, empty for legacy backup
+	Created time.Time
+	Server  string
+	// Exported keys, the keypair of user object has the public key followed by the private key
+	Blobs [][]byte
+}
+
+// Backup file, written as JSON
+type backupFile struct {
+	Format   string    `json:"format"`
+	Version  int       `json:"version"`
+	Labels   []string  `json:"labels"`
+	KeyType  string    `json:"keyType"`
+	UserType int       `json:"userType"`
+	Created  time.Time `json:"created"`
+	Server   string    `json:"server"`
+	Header   string    `json:"header"`
+	// SHA-256 of the metadata and blobs, or ciphertext if protected by a passphrase, hex encoded
+	Checksum string   `json:"checksum"`
+	Blobs    [][]byte `json:"blobs,omitempty"`
+	// Blobs encrypted with the passphrase
+	Encryption *backupEncryption `json:"encryption,omitempty"`
+	Ciphertext []byte            `json:"ciphertext,omitempty"`
+}
+
+// BackupOption configure the writing and reading of the backup file
+type BackupOption func(*backupOptions)
+
+type backupOptions struct {
+	passphrase string
+	scrypt     ScryptParams
+	overwrite  bool
+}
+
+// WithPassphrase encrypt the exported keys with the passphrase when writing the backup,
+// and decrypt them when reading it
+func WithPassphrase(passphrase string) BackupOption {
+	return func(o *backupOptions) {
+		o.passphrase = passphrase
+	}
+}
+
+// WithScryptParams set the cost of the key derivation of the passphrase
+// The parameters are written in the file, so they can be strengthened later
+func WithScryptParams(params ScryptParams) BackupOption {
+	return func(o *backupOptions) {
+		o.scrypt = params
+	}
+}
+
+func newBackupOptions(opts []BackupOption) *backupOptions {
+	o := &backupOptions{
+		scrypt: ScryptParams{N: DEFAULT_SCRYPT_N, R: DEFAULT_SCRYPT_R, P: DEFAULT_SCRYPT_P},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func newBackupBlob(header string, userType int, blobs [][]byte) *BackupBlob {
+	b := &BackupBlob{
+		Header:   header,
+		UserType: userType,
+		Blobs:    blobs,
+	}
+	if fields := strings.SplitN(header, ";", 3); len(fields) == 3 && fields[0] == "KSEMA" {
+		b.KeyType = fields[1]
+		b.Label = fields[2]
+	}
+	return b
+}
+
+// Perform backup of a keylabel
+// Return the exported keys in memory, use WriteTo or WriteFile to persist them
+//
+// User object does not need to specified the key label used, except for user slot
+func (k *Ksema) BackupKey(ctx context.Context, keyLabel string) (*BackupBlob, error) {
+	if k.getUserType() > USER_OBJECT && keyLabel == "" {
+		return nil, ErrNoKeyLabel
+	}
+
+	info := callInfoFromContext(ctx)
+	if info == nil {
+		info = &CallInfo{}
+		ctx = WithCallInfo(ctx, info)
+	}
+
+	backup, err := operationBackup(ctx, k.request, k.getUserType(), keyLabel)
+	if err != nil {
+		return nil, err
+	}
+	backup.Created = time.Now().UTC()
+	backup.Server = info.Endpoint
+	return backup, nil
+}
+
+// WriteTo write the backup as versioned JSON file with checksum
+func (b *BackupBlob) WriteTo(w io.Writer) (int64, error) {
+	return b.Encode(w)
+}
+
+// Encode is like WriteTo with options, e.g. WithPassphrase to encrypt the exported keys
+func (b *BackupBlob) Encode(w io.Writer, opts ...BackupOption) (int64, error) {
+	o := newBackupOptions(opts)
+
+	file := backupFile{
+		Format:   BACKUP_FORMAT,
+		Version:  BACKUP_VERSION,
+		KeyType:  b.KeyType,
+		UserType: b.UserType,
+		Created:  b.Created,
+		Server:   b.Server,
+		Header:   b.Header,
+	}
+	if b.Label != "" {
+		file.Labels = []string{b.Label}
+	}
+	if o.passphrase == "" {
+		file.Blobs = b.Blobs
+		file.Checksum = file.checksum(b.Blobs)
+	} else {
+		enc, cipherText, err := encryptBackup(o.passphrase, o.scrypt, file.metadata(), b.Blobs)
+		if err != nil {
+			return 0, err
+		}
+		file.Encryption = enc
+		file.Ciphertext = cipherText
+		file.Checksum = file.checksum([][]byte{cipherText})
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.Write(append(data, '\n'))
+	return int64(n), err
+}
+
+// WriteFile write the backup to the file atomically, readable only by the owner
+func (b *BackupBlob) WriteFile(path string, opts ...BackupOption) error {
+	var buf bytes.Buffer
+	if _, err := b.Encode(&buf, opts...); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// ReadBackup read and validate the backup written by WriteTo
+// The legacy backup file ("header\nexported") is also accepted
+// WithPassphrase is required if the backup is protected by a passphrase
+func ReadBackup(r io.Reader, opts ...BackupOption) (*BackupBlob, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MAX_BACKUP_SIZE+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MAX_BACKUP_SIZE {
+		return nil, fmt.Errorf("%w: larger than %d bytes", ErrInvalidBackup, MAX_BACKUP_SIZE)
+	}
+
+	if isLegacyBackup(data) {
+		return ReadLegacyBackup(bytes.NewReader(data))
+	}
+
+	var file backupFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) && syntaxErr.Offset >= int64(len(data)) {
+			return nil, fmt.Errorf("%w: file is truncated", ErrInvalidBackup)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if err := file.validate(); err != nil {
+		return nil, err
+	}
+
+	blobs := file.Blobs
+	if file.Encryption != nil {
+		o := newBackupOptions(opts)
+		if o.passphrase == "" {
+			return nil, ErrPassphraseRequired
+		}
+		if blobs, err = file.Encryption.decrypt(o.passphrase, file.metadata(), file.Ciphertext); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkBackupBlobs(blobs, file.UserType); err != nil {
+		return nil, err
+	}
+
+	b := newBackupBlob(file.Header, file.UserType, blobs)
+	b.Created = file.Created
+	b.Server = file.Server
+	return b, nil
+}
+
+// Read the backup file
+// The private key file of the legacy keypair of user object is read too if it exists
+func readBackupFile(fileName string, opts ...BackupOption) (*BackupBlob, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, MAX_BACKUP_SIZE+1))
+	if err != nil {
+		return nil, err
+	}
+	if !isLegacyBackup(data) {
+		return ReadBackup(bytes.NewReader(data), opts...)
+	}
+
+	priv, err := os.Open(LegacyPrivateFile(fileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ReadLegacyBackup(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer priv.Close()
+	return ReadLegacyBackupPair(bytes.NewReader(data), priv)
+}
+
+// Check if the file is not the JSON backup
+func isLegacyBackup(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	return len(trimmed) == 0 || trimmed[0] != '{'
+}
+
+// Check the format, version, checksum and consistency of the file
+func (f *backupFile) validate() error {
+	if f.Format != BACKUP_FORMAT {
+		return fmt.Errorf("%w: unknown format %q", ErrInvalidBackup, f.Format)
+	}
+	if f.Version != BACKUP_VERSION {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidBackup, f.Version)
+	}
+	checked := f.Blobs
+	if f.Encryption != nil {
+		if len(f.Blobs) > 0 || len(f.Ciphertext) == 0 {
+			return fmt.Errorf("%w: encrypted backup must only have the ciphertext", ErrInvalidBackup)
+		}
+		checked = [][]byte{f.Ciphertext}
+	}
+	if f.Checksum != f.checksum(checked) {
+		return fmt.Errorf("%w: checksum mismatch", ErrInvalidBackup)
+	}
+
+	// The metadata must match the header returned by the server
+	parsed := newBackupBlob(f.Header, f.UserType, nil)
+	if f.KeyType != parsed.KeyType {
+		return fmt.Errorf("%w: key type %q does not match the header", ErrInvalidBackup, f.KeyType)
+	}
+	if parsed.Label != "" && (len(f.Labels) == 0 || f.Labels[0] != parsed.Label) {
+		return fmt.Errorf("%w: labels %q do not match the header", ErrInvalidBackup, f.Labels)
+	}
+	return nil
+}
+
+// Check the number of exported keys
+func checkBackupBlobs(blobs [][]byte, userType int) error {
+	if len(blobs) == 0 {
+		return fmt.Errorf("%w: no exported key", ErrInvalidBackup)
+	}
+	for i, blob := range blobs {
+		if len(blob) == 0 {
+			return fmt.Errorf("%w: exported key %d is empty", ErrInvalidBackup, i+1)
+		}
+	}
+	if userType == USER_OBJECT && len(blobs) != 2 {
+		return fmt.Errorf("%w: keypair of user object has %d exported keys instead of 2", ErrInvalidBackup, len(blobs))
+	}
+	return nil
+}
+
+// ReadLegacyBackup read the backup file written by the previous version of Backup
+// It contains the header, a new line and the exported key, which is everything after the first new line
+func ReadLegacyBackup(r io.Reader) (*BackupBlob, error) {
+	header, blob, err := readLegacyFile(r)
+	if err != nil {
+		return nil, err
+	}
+	return newBackupBlob(header, 0, [][]byte{blob}), nil
+}
+
+// ReadLegacyBackupPair read the keypair of user object written by the previous version of Backup
+// The public key is in the backup file and the private key in the file with the "priv" prefix, see LegacyPrivateFile
+func ReadLegacyBackupPair(pub, priv io.Reader) (*BackupBlob, error) {
+	pubHeader, pubBlob, err := readLegacyFile(pub)
+	if err != nil {
+		return nil, err
+	}
+	privHeader, privBlob, err := readLegacyFile(priv)
+	if err != nil {
+		return nil, fmt.Errorf("private key file: %w", err)
+	}
+	if pubHeader != privHeader {
+		return nil, fmt.Errorf("%w: header of the private key file %q does not match %q", ErrInvalidBackup, privHeader, pubHeader)
+	}
+	return newBackupBlob(pubHeader, USER_OBJECT, [][]byte{pubBlob, privBlob}), nil
+}
+
+// LegacyPrivateFile return the file of the private key written by the previous version of Backup for user object
+// The prefix is added to the whole file name, like the previous version did
+func LegacyPrivateFile(fileName string) string {
+	return "priv" + fileName
+}
+
+// Read the header and the exported key of a legacy backup file, the exported key is kept unchanged
+func readLegacyFile(r io.Reader) (string, []byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MAX_BACKUP_SIZE+1))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(data) > MAX_BACKUP_SIZE {
+		return "", nil, fmt.Errorf("%w: larger than %d bytes", ErrInvalidBackup, MAX_BACKUP_SIZE)
+	}
+
+	header, blob, found := bytes.Cut(data, []byte("\n"))
+	if !found || len(blob) == 0 {
+		return "", nil, fmt.Errorf("%w: no exported key after the header", ErrInvalidBackup)
+	}
+	return string(header), blob, nil
+}
+
+// Metadata of the file, length prefixed
+// It is covered by the checksum and is the additional data of the passphrase encryption
+func (f *backupFile) metadata() []byte {
+	var buf []byte
+	appendField := func(field string) {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(field)))
+		buf = append(buf, field...)
+	}
+
+	appendField(f.Format)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(f.Version))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(f.Labels)))
+	for _, label := range f.Labels {
+		appendField(label)
+	}
+	appendField(f.KeyType)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(f.UserType))
+	appendField(f.Created.UTC().Format(time.RFC3339Nano))
+	appendField(f.Server)
+	appendField(f.Header)
+	return buf
+}
+
+// SHA-256 of the metadata and the length prefixed blobs
+func (f *backupFile) checksum(blobs [][]byte) string {
+	h := sha256.New()
+	h.Write(f.metadata())
+	for _, blob := range blobs {
+		h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(blob))))
+		h.Write(blob)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
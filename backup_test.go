@@ -0,0 +1,92 @@
+package ksema_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/suhailiealx/ksema-sdk-go"
+)
+
+func TestReadLegacyBackup(t *testing.T) {
+	// The exported key is everything after the first new line, unchanged
+	exported := "line1\nline2 \r\n\n"
+	backup, err := ksema.ReadLegacyBackup(strings.NewReader("KSEMA;SYM;AES01\n" + exported))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup.Label != "AES01" || backup.KeyType != "SYM" {
+		t.Fatalf("got label %q and key type %q", backup.Label, backup.KeyType)
+	}
+	if len(backup.Blobs) != 1 || string(backup.Blobs[0]) != exported {
+		t.Fatalf("got blobs %q, want [%q]", backup.Blobs, exported)
+	}
+
+	for _, data := range []string{"", "KSEMA;SYM;AES01", "KSEMA;SYM;AES01\n"} {
+		if _, err := ksema.ReadLegacyBackup(strings.NewReader(data)); !errors.Is(err, ksema.ErrInvalidBackup) {
+			t.Fatalf("%q: got %v, want ErrInvalidBackup", data, err)
+		}
+	}
+}
+
+func TestReadLegacyBackupPair(t *testing.T) {
+	backup, err := ksema.ReadLegacyBackupPair(strings.NewReader("KSEMA;PAIR;object\npublic"), strings.NewReader("KSEMA;PAIR;object\nprivate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup.UserType != ksema.USER_OBJECT || len(backup.Blobs) != 2 ||
+		string(backup.Blobs[0]) != "public" || string(backup.Blobs[1]) != "private" {
+		t.Fatalf("got user type %d and blobs %q", backup.UserType, backup.Blobs)
+	}
+
+	_, err = ksema.ReadLegacyBackupPair(strings.NewReader("KSEMA;PAIR;object\npublic"), strings.NewReader("KSEMA;PAIR;other\nprivate"))
+	if !errors.Is(err, ksema.ErrInvalidBackup) {
+		t.Fatalf("mismatched headers: got %v, want ErrInvalidBackup", err)
+	}
+
+	if got := ksema.LegacyPrivateFile("object.bak"); got != "privobject.bak" {
+		t.Fatalf("got private file %q", got)
+	}
+}
+
+func TestBackupChecksum(t *testing.T) {
+	backup, err := ksema.ReadLegacyBackup(strings.NewReader("KSEMA;SYM;AES01\nexported"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup.Created = time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)
+	backup.Server = "127.0.0.1:8443"
+
+	var buf bytes.Buffer
+	if _, err := backup.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ksema.ReadBackup(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every metadata field is covered by the checksum
+	tampers := map[string]any{
+		"userType": ksema.USER_OBJECT,
+		"created":  "2027-01-02T03:04:05Z",
+		"server":   "10.0.0.1:8443",
+		"labels":   []string{"AES01", "AES02"},
+	}
+	for field, value := range tampers {
+		var file map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &file); err != nil {
+			t.Fatal(err)
+		}
+		file[field] = value
+		data, err := json.Marshal(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ksema.ReadBackup(bytes.NewReader(data)); !errors.Is(err, ksema.ErrInvalidBackup) {
+			t.Fatalf("%s changed: got %v, want ErrInvalidBackup", field, err)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package ksema
+
+import (
+	"context"
+	"sync"
+)
+
+// Default number of concurrent requests of the batch operations
+const DEFAULT_BATCH_CONCURRENCY = 8
+
+// BatchItem is an input of the batch operations
+type BatchItem struct {
+	// Data to be processed
+	Data []byte
+	// Signature to be verified, only used by verify
+	Signature []byte
+	// Key label, user object does not need to specified it
+	Label string
+}
+
+// BatchResult is the result of a batch item
+type BatchResult struct {
+	// Index of the item in the batch or stream
+	Index int
+	// Output of the operation, nil for verify
+	Data []byte
+	// Error of the item, nil if success
+	Err error
+}
+
+// WithBatchConcurrency set the number of concurrent requests of the batch operations
+// Default is 8
+func WithBatchConcurrency(n int) Option {
+	return func(c *config) {
+		c.batchConcurrency = n
+	}
+}
+
+type batchFunc func(ctx context.Context, item BatchItem) ([]byte, error)
+
+// EncryptBatch encrypt every item concurrently
+// Return the results in the same order as the items
+func (k *Ksema) EncryptBatch(ctx context.Context, items []BatchItem) []BatchResult {
+	return k.runBatch(ctx, items, k.encryptItem)
+}
+
+// DecryptBatch decrypt every item concurrently
+// Return the results in the same order as the items
+func (k *Ksema) DecryptBatch(ctx context.Context, items []BatchItem) []BatchResult {
+	return k.runBatch(ctx, items, k.decryptItem)
+}
+
+// SignBatch sign every item concurrently
+// Return the results in the same order as the items
+func (k *Ksema) SignBatch(ctx context.Context, items []BatchItem) []BatchResult {
+	return k.runBatch(ctx, items, k.signItem)
+}
+
+// VerifyBatch verify the signature of every item concurrently
+// Return the results in the same order as the items, the error is nil if the signature is valid
+func (k *Ksema) VerifyBatch(ctx context.Context, items []BatchItem) []BatchResult {
+	return k.runBatch(ctx, items, k.verifyItem)
+}
+
+// EncryptStream encrypt the items received from the channel concurrently
+// The results are sent in the same order as the items, the channel is closed when the input is closed
+func (k *Ksema) EncryptStream(ctx context.Context, in <-chan BatchItem) <-chan BatchResult {
+	return k.runStream(ctx, in, k.encryptItem)
+}
+
+// DecryptStream decrypt the items received from the channel concurrently
+// The results are sent in the same order as the items, the channel is closed when the input is closed
+func (k *Ksema) DecryptStream(ctx context.Context, in <-chan BatchItem) <-chan BatchResult {
+	return k.runStream(ctx, in, k.decryptItem)
+}
+
+// SignStream sign the items received from the channel concurrently
+// The results are sent in the same order as the items, the channel is closed when the input is closed
+func (k *Ksema) SignStream(ctx context.Context, in <-chan BatchItem) <-chan BatchResult {
+	return k.runStream(ctx, in, k.signItem)
+}
+
+// VerifyStream verify the items received from the channel concurrently
+// The results are sent in the same order as the items, the channel is closed when the input is closed
+func (k *Ksema) VerifyStream(ctx context.Context, in <-chan BatchItem) <-chan BatchResult {
+	return k.runStream(ctx, in, k.verifyItem)
+}
+
+func (k *Ksema) encryptItem(ctx context.Context, item BatchItem) ([]byte, error) {
+	return k.EncryptContext(ctx, item.Data, item.Label)
+}
+
+func (k *Ksema) decryptItem(ctx context.Context, item BatchItem) ([]byte, error) {
+	return k.DecryptContext(ctx, item.Data, item.Label)
+}
+
+func (k *Ksema) signItem(ctx context.Context, item BatchItem) ([]byte, error) {
+	return k.SignContext(ctx, item.Data, item.Label)
+}
+
+func (k *Ksema) verifyItem(ctx context.Context, item BatchItem) ([]byte, error) {
+	return nil, k.VerifyContext(ctx, item.Data, item.Signature, item.Label)
+}
+
+// Run the function on every item with bounded concurrency
+func (k *Ksema) runBatch(ctx context.Context, items []BatchItem, fn batchFunc) []BatchResult {
+	results := make([]BatchResult, len(items))
+	sem := make(chan struct{}, k.batchConcurrency)
+
+	var wg sync.WaitGroup
+	for i, item := range items {
+		results[i].Index = i
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i].Data, results[i].Err = fn(ctx, item)
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// Run the function on every item of the stream with bounded concurrency
+// The order is kept by queueing the pending result of each item
+func (k *Ksema) runStream(ctx context.Context, in <-chan BatchItem, fn batchFunc) <-chan BatchResult {
+	out := make(chan BatchResult, k.batchConcurrency)
+	pending := make(chan chan BatchResult, k.batchConcurrency)
+	sem := make(chan struct{}, k.batchConcurrency)
+
+	go func() {
+		defer close(pending)
+
+		for index := 0; ; index++ {
+			var item BatchItem
+			var ok bool
+			select {
+			case item, ok = <-in:
+				if !ok {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+
+			result := make(chan BatchResult, 1)
+			select {
+			case pending <- result:
+			case <-ctx.Done():
+				return
+			}
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				result <- BatchResult{Index: index, Err: ctx.Err()}
+				return
+			}
+
+			go func() {
+				defer func() { <-sem }()
+				data, err := fn(ctx, item)
+				result <- BatchResult{Index: index, Data: data, Err: err}
+			}()
+		}
+	}()
+
+	go func() {
+		defer close(out)
+
+		for result := range pending {
+			var res BatchResult
+			select {
+			case res = <-result:
+			case <-ctx.Done():
+				drain(pending)
+				return
+			}
+
+			select {
+			case out <- res:
+			case <-ctx.Done():
+				drain(pending)
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// Drain the pending results, their buffered channel let the goroutines finish
+func drain(pending <-chan chan BatchResult) {
+	for range pending {
+	}
+}
@@ -0,0 +1,214 @@
+package ksema_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/suhailiealx/ksema-sdk-go"
+	"github.com/suhailiealx/ksema-sdk-go/ksematest"
+)
+
+func TestStreamConcurrency(t *testing.T) {
+	srv := newTestServer(t)
+
+	const concurrency = 2
+	var running, peak atomic.Int32
+	count := func(ctx context.Context, req *ksema.ServiceRequest, invoke ksema.Invoker) (*ksema.ServiceResponse, error) {
+		if req.Operation != ksema.FunctionEncrypt {
+			return invoke(ctx, req)
+		}
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return invoke(ctx, req)
+	}
+
+	k := newTestClient(t, srv, ksematest.UserBrigade,
+		ksema.WithBatchConcurrency(concurrency),
+		ksema.WithInterceptors(count),
+	)
+	genKey(t, k, "AES01", "")
+
+	in := make(chan ksema.BatchItem)
+	go func() {
+		defer close(in)
+		for i := range 20 {
+			in <- ksema.BatchItem{Data: fmt.Appendf(nil, "item %d", i), Label: "AES01"}
+		}
+	}()
+
+	index := 0
+	for result := range k.EncryptStream(context.Background(), in) {
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+		if result.Index != index {
+			t.Fatalf("got result %d, want %d", result.Index, index)
+		}
+		index++
+	}
+	if index != 20 {
+		t.Fatalf("got %d results, want 20", index)
+	}
+	if p := peak.Load(); p > concurrency {
+		t.Fatalf("%d concurrent requests, want at most %d", p, concurrency)
+	}
+}
+
+func TestStreamCanceled(t *testing.T) {
+	srv := newTestServer(t)
+
+	k := newTestClient(t, srv, ksematest.UserBrigade, ksema.WithBatchConcurrency(2))
+	genKey(t, k, "AES01", "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan ksema.BatchItem)
+	go func() {
+		for {
+			select {
+			case in <- ksema.BatchItem{Data: []byte("data"), Label: "AES01"}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// The results are not received, the stream must still end on cancel
+	out := k.EncryptStream(ctx, in)
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for range out {
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream not closed after cancel")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	srv := newTestServer(t)
+	k := newTestClient(t, srv, ksematest.UserBrigade, ksema.WithBatchConcurrency(3))
+	genKey(t, k, "AES01", "")
+	genKey(t, k, "PUB01", "PRIV01")
+	ctx := context.Background()
+
+	// Item 4 use a label which does not exist
+	items := make([]ksema.BatchItem, 10)
+	for i := range items {
+		items[i] = ksema.BatchItem{Data: fmt.Appendf(nil, "item %d", i), Label: "AES01"}
+	}
+	items[4].Label = "MISSING"
+
+	encrypted := k.EncryptBatch(ctx, items)
+	decryptItems := make([]ksema.BatchItem, len(items))
+	for i, result := range encrypted {
+		if result.Index != i {
+			t.Fatalf("got result %d at %d", result.Index, i)
+		}
+		if i == 4 {
+			var kErr *ksema.Error
+			if !errors.As(result.Err, &kErr) || kErr.Label != "MISSING" {
+				t.Fatalf("item with missing label: got %v, want *Error of its label", result.Err)
+			}
+			decryptItems[i] = ksema.BatchItem{Data: items[i].Data, Label: "MISSING"}
+			continue
+		}
+		if result.Err != nil {
+			t.Fatalf("item %d: %v", i, result.Err)
+		}
+		decryptItems[i] = ksema.BatchItem{Data: result.Data, Label: "AES01"}
+	}
+
+	for i, result := range k.DecryptBatch(ctx, decryptItems) {
+		if i == 4 {
+			if result.Err == nil {
+				t.Fatal("item with missing label decrypted")
+			}
+			continue
+		}
+		if result.Err != nil {
+			t.Fatalf("item %d: %v", i, result.Err)
+		}
+		if !bytes.Equal(result.Data, items[i].Data) {
+			t.Fatalf("item %d: decrypted %q, want %q", i, result.Data, items[i].Data)
+		}
+	}
+
+	signItems := make([]ksema.BatchItem, 5)
+	for i := range signItems {
+		digest := sha256.Sum256(fmt.Appendf(nil, "item %d", i))
+		signItems[i] = ksema.BatchItem{Data: digest[:], Label: "PRIV01"}
+	}
+	verifyItems := make([]ksema.BatchItem, len(signItems))
+	for i, result := range k.SignBatch(ctx, signItems) {
+		if result.Err != nil {
+			t.Fatalf("item %d: %v", i, result.Err)
+		}
+		verifyItems[i] = ksema.BatchItem{Data: signItems[i].Data, Signature: result.Data, Label: "PUB01"}
+	}
+	// Signature of another item
+	verifyItems[2].Signature = verifyItems[3].Signature
+
+	for i, result := range k.VerifyBatch(ctx, verifyItems) {
+		if i == 2 {
+			if !errors.Is(result.Err, ksema.ErrFailed) {
+				t.Fatalf("wrong signature: got %v, want ErrFailed", result.Err)
+			}
+			continue
+		}
+		if result.Err != nil {
+			t.Fatalf("item %d: %v", i, result.Err)
+		}
+	}
+}
+
+func TestBatchCanceled(t *testing.T) {
+	srv := newTestServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The first request cancel the batch
+	cancelFirst := func(reqCtx context.Context, req *ksema.ServiceRequest, invoke ksema.Invoker) (*ksema.ServiceResponse, error) {
+		if req.Operation == ksema.FunctionEncrypt {
+			cancel()
+		}
+		return invoke(reqCtx, req)
+	}
+	k := newTestClient(t, srv, ksematest.UserBrigade,
+		ksema.WithBatchConcurrency(1),
+		ksema.WithInterceptors(cancelFirst),
+	)
+	genKey(t, k, "AES01", "")
+
+	items := make([]ksema.BatchItem, 5)
+	for i := range items {
+		items[i] = ksema.BatchItem{Data: []byte("data"), Label: "AES01"}
+	}
+	results := k.EncryptBatch(ctx, items)
+	if len(results) != len(items) {
+		t.Fatalf("got %d results, want %d", len(results), len(items))
+	}
+	for _, result := range results[1:] {
+		if !errors.Is(result.Err, context.Canceled) {
+			t.Fatalf("item %d after cancel: got %v, want context.Canceled", result.Index, result.Err)
+		}
+	}
+}
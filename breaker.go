@@ -0,0 +1,211 @@
+package ksema
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_BREAKER_FAILURE_THRESHOLD = 5
+	DEFAULT_BREAKER_OPEN_TIMEOUT      = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned when the circuit breaker of the endpoint is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerState is the state of a circuit breaker
+type BreakerState int
+
+const (
+	// Requests are sent normally
+	BreakerClosed BreakerState = iota
+	// Requests fail fast with ErrCircuitOpen
+	BreakerOpen
+	// A ping is probing the recovery of the endpoint
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerMetrics receive the events of the circuit breakers
+type BreakerMetrics interface {
+	// Called for every request result recorded by the breaker
+	ObserveResult(endpoint string, failure bool)
+	// Called when a request is rejected because the breaker is open
+	ObserveRejected(endpoint string)
+	// Called on every state transition
+	ObserveState(endpoint string, state BreakerState)
+}
+
+// CircuitBreaker configure the circuit breaker of every endpoint
+//
+// The breaker open after consecutive transport failures or 5xx responses,
+// after the open timeout a ping probe the endpoint before closing it again
+type CircuitBreaker struct {
+	// Consecutive failures which open the breaker
+	// Default is 5
+	FailureThreshold int
+	// How long the breaker stay open before probing
+	// Default is 30 seconds
+	OpenTimeout time.Duration
+	// Called on every state transition
+	OnStateChange func(endpoint string, from, to BreakerState)
+	// Metrics hook, may be nil
+	Metrics BreakerMetrics
+}
+
+// WithCircuitBreaker enable the circuit breaker on every endpoint
+func WithCircuitBreaker(cb CircuitBreaker) Option {
+	return func(c *config) {
+		c.breaker = &cb
+	}
+}
+
+type breaker struct {
+	cfg      CircuitBreaker
+	endpoint string
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+}
+
+func newBreaker(cfg CircuitBreaker, endpoint string) *breaker {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = DEFAULT_BREAKER_FAILURE_THRESHOLD
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = DEFAULT_BREAKER_OPEN_TIMEOUT
+	}
+	return &breaker{cfg: cfg, endpoint: endpoint}
+}
+
+// Check if a request can be sent
+// Return true if the caller must probe the endpoint first
+func (b *breaker) acquire() (bool, error) {
+	b.mu.Lock()
+
+	switch b.state {
+	case BreakerClosed:
+		b.mu.Unlock()
+		return false, nil
+	case BreakerOpen:
+		if time.Since(b.openedAt) >= b.cfg.OpenTimeout {
+			from := b.setState(BreakerHalfOpen)
+			b.mu.Unlock()
+			b.notify(from, BreakerHalfOpen)
+			return true, nil
+		}
+	}
+	b.mu.Unlock()
+
+	if b.cfg.Metrics != nil {
+		b.cfg.Metrics.ObserveRejected(b.endpoint)
+	}
+	return false, fmt.Errorf("%w: %s", ErrCircuitOpen, b.endpoint)
+}
+
+// Record the result of a request or probe
+func (b *breaker) record(failure bool) {
+	if b.cfg.Metrics != nil {
+		b.cfg.Metrics.ObserveResult(b.endpoint, failure)
+	}
+
+	b.mu.Lock()
+	from, to := b.state, b.state
+	switch {
+	case !failure:
+		b.failures = 0
+		if b.state == BreakerHalfOpen {
+			to = BreakerClosed
+		}
+	case b.state == BreakerHalfOpen:
+		to = BreakerOpen
+	default:
+		b.failures++
+		if b.state == BreakerClosed && b.failures >= b.cfg.FailureThreshold {
+			to = BreakerOpen
+		}
+	}
+	if to != from {
+		b.setState(to)
+	}
+	b.mu.Unlock()
+
+	if to != from {
+		b.notify(from, to)
+	}
+}
+
+// Change the state, the mutex must be held
+// Return the previous state
+func (b *breaker) setState(state BreakerState) BreakerState {
+	from := b.state
+	b.state = state
+	if state == BreakerOpen {
+		b.openedAt = time.Now()
+	}
+	if state == BreakerClosed {
+		b.failures = 0
+	}
+	return from
+}
+
+func (b *breaker) notify(from, to BreakerState) {
+	if b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(b.endpoint, from, to)
+	}
+	if b.cfg.Metrics != nil {
+		b.cfg.Metrics.ObserveState(b.endpoint, to)
+	}
+}
+
+// Check if the error count as a failure of the endpoint
+func isBreakerFailure(err error) bool {
+	if isConnectionError(err) {
+		return true
+	}
+	var kErr *Error
+	return errors.As(err, &kErr) && kErr.HTTPStatus >= 500
+}
+
+// Let the request through the breaker of the endpoint, probing it with ping if needed
+func (k *Ksema) acquireBreaker(ctx context.Context, ep *endpoint) error {
+	if ep.breaker == nil {
+		return nil
+	}
+
+	probe, err := ep.breaker.acquire()
+	if err != nil || !probe {
+		return err
+	}
+
+	_, err = k.send(ctx, ep, ServiceRequest{Operation: FunctionPing})
+	ep.breaker.record(err != nil)
+	if err != nil {
+		return fmt.Errorf("%w: %s: probe failed: %w", ErrCircuitOpen, ep.address, err)
+	}
+	return nil
+}
+
+// Record the result of the request in the breaker of the endpoint
+func (k *Ksema) recordBreaker(ep *endpoint, err error) {
+	if ep.breaker == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	ep.breaker.record(isBreakerFailure(err))
+}
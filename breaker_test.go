@@ -0,0 +1,78 @@
+package ksema_test
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/suhailiealx/ksema-sdk-go"
+	"github.com/suhailiealx/ksema-sdk-go/ksematest"
+)
+
+// Transport failing the requests on demand
+type flakyTransport struct {
+	base http.RoundTripper
+	fail atomic.Bool
+}
+
+func (f *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if f.fail.Load() {
+		return nil, errors.New("connection refused")
+	}
+	return f.base.RoundTrip(req)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	srv := newTestServer(t)
+
+	transport := &flakyTransport{
+		base: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: srv.CertPool()}},
+	}
+
+	var mu sync.Mutex
+	var transitions []ksema.BreakerState
+	k := newTestClient(t, srv, ksematest.UserBrigade,
+		ksema.WithHTTPClient(&http.Client{Transport: transport}),
+		ksema.WithCircuitBreaker(ksema.CircuitBreaker{
+			FailureThreshold: 2,
+			OpenTimeout:      50 * time.Millisecond,
+			OnStateChange: func(_ string, _, to ksema.BreakerState) {
+				mu.Lock()
+				transitions = append(transitions, to)
+				mu.Unlock()
+			},
+		}),
+	)
+
+	transport.fail.Store(true)
+	for range 2 {
+		if err := k.Ping(); err == nil || errors.Is(err, ksema.ErrCircuitOpen) {
+			t.Fatalf("ping with failing transport: got %v, want transport error", err)
+		}
+	}
+	if err := k.Ping(); !errors.Is(err, ksema.ErrCircuitOpen) {
+		t.Fatalf("ping after the threshold: got %v, want ErrCircuitOpen", err)
+	}
+
+	transport.fail.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	if err := k.Ping(); err != nil {
+		t.Fatalf("ping after the open timeout: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []ksema.BreakerState{ksema.BreakerOpen, ksema.BreakerHalfOpen, ksema.BreakerClosed}
+	if len(transitions) != len(want) {
+		t.Fatalf("got transitions %v, want %v", transitions, want)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Fatalf("got transitions %v, want %v", transitions, want)
+		}
+	}
+}
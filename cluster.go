@@ -0,0 +1,250 @@
+package ksema
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_HEALTH_CHECK_INTERVAL = 10 * time.Second
+	DEFAULT_COOL_OFF              = 30 * time.Second
+)
+
+// Strategy define how the endpoint serving a request is selected
+type Strategy int
+
+const (
+	// Use the first healthy endpoint in the given order
+	StrategyPriority Strategy = iota
+	// Rotate between the healthy endpoints
+	StrategyRoundRobin
+	// Use the healthy endpoint with the lowest ping latency
+	StrategyLeastLatency
+)
+
+// WithStrategy set the endpoint selection strategy
+// Default is StrategyPriority
+func WithStrategy(strategy Strategy) Option {
+	return func(c *config) {
+		c.strategy = strategy
+	}
+}
+
+// WithHealthCheckInterval set the interval of the background ping to every endpoint
+// Zero disable the health check
+//
+// Default is 10 seconds for NewCluster, disabled for single endpoint
+func WithHealthCheckInterval(interval time.Duration) Option {
+	return func(c *config) {
+		c.healthCheckInterval = &interval
+	}
+}
+
+// WithCoolOff set how long a failing endpoint is marked down
+// Default is 30 seconds
+func WithCoolOff(coolOff time.Duration) Option {
+	return func(c *config) {
+		c.coolOff = coolOff
+	}
+}
+
+// EndpointStatus report the health of an endpoint
+type EndpointStatus struct {
+	Address   string
+	Healthy   bool
+	Latency   time.Duration
+	DownUntil time.Time
+	LastError error
+	LastPing  time.Time
+}
+
+// CallInfo report how a call was served
+// Use WithCallInfo to receive it
+type CallInfo struct {
+	// Address of the endpoint which served the last attempt
+	Endpoint string
+	// Number of attempts made
+	Attempts int
+}
+
+type callInfoKey struct{}
+
+// WithCallInfo return a context which make the call fill the given info
+func WithCallInfo(ctx context.Context, info *CallInfo) context.Context {
+	return context.WithValue(ctx, callInfoKey{}, info)
+}
+
+func callInfoFromContext(ctx context.Context) *CallInfo {
+	info, _ := ctx.Value(callInfoKey{}).(*CallInfo)
+	return info
+}
+
+// Ksema server with its own session
+type endpoint struct {
+	address string
+	breaker *breaker
+
+	// Guard the state below
+	mu        sync.RWMutex
+	sessID    string
+	authAt    time.Time
+	authCall  *authCall
+	latency   time.Duration
+	downUntil time.Time
+	lastErr   error
+	lastPing  time.Time
+}
+
+func (ep *endpoint) sessionID() string {
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
+	return ep.sessID
+}
+
+// Return how long the session has been established
+func (ep *endpoint) sessionAge() time.Duration {
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
+	if ep.authAt.IsZero() {
+		return 0
+	}
+	return time.Since(ep.authAt)
+}
+
+func (ep *endpoint) healthy(now time.Time) bool {
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
+	return !now.Before(ep.downUntil)
+}
+
+func (ep *endpoint) getLatency() time.Duration {
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
+	return ep.latency
+}
+
+// Mark the endpoint as healthy and update the moving average of the latency
+func (ep *endpoint) markUp(latency time.Duration) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	ep.downUntil = time.Time{}
+	ep.lastErr = nil
+	if ep.latency == 0 {
+		ep.latency = latency
+	} else {
+		ep.latency = (ep.latency*7 + latency) / 8
+	}
+}
+
+// Mark the endpoint as down for the cool-off period
+func (ep *endpoint) markDown(err error, coolOff time.Duration) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	ep.downUntil = time.Now().Add(coolOff)
+	ep.lastErr = err
+}
+
+func (ep *endpoint) status(now time.Time) EndpointStatus {
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
+
+	return EndpointStatus{
+		Address:   ep.address,
+		Healthy:   !now.Before(ep.downUntil),
+		Latency:   ep.latency,
+		DownUntil: ep.downUntil,
+		LastError: ep.lastErr,
+		LastPing:  ep.lastPing,
+	}
+}
+
+// NewCluster return the pointer of Ksema object using several Ksema servers
+//
+// Each endpoint has its own session, the key exchange must be success on at least one endpoint
+// Failing endpoints are marked down and tried again by the background health check
+func NewCluster(ctx context.Context, endpoints []string, creds Credentials, opts ...Option) (*Ksema, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("no endpoint specified")
+	}
+
+	cfg := defaultConfig()
+	interval := DEFAULT_HEALTH_CHECK_INTERVAL
+	cfg.healthCheckInterval = &interval
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	return newKsema(ctx, endpoints, creds, cfg)
+}
+
+// Endpoints return the health of every endpoint
+func (k *Ksema) Endpoints() []EndpointStatus {
+	now := time.Now()
+	status := make([]EndpointStatus, 0, len(k.endpoints))
+	for _, ep := range k.endpoints {
+		status = append(status, ep.status(now))
+	}
+	return status
+}
+
+// Return the endpoints in the order they should be tried
+// Healthy endpoints come first, followed by the one marked down as last resort
+func (k *Ksema) selectEndpoints() []*endpoint {
+	if len(k.endpoints) == 1 {
+		return k.endpoints
+	}
+
+	now := time.Now()
+	healthy := make([]*endpoint, 0, len(k.endpoints))
+	var down []*endpoint
+	for _, ep := range k.endpoints {
+		if ep.healthy(now) {
+			healthy = append(healthy, ep)
+		} else {
+			down = append(down, ep)
+		}
+	}
+
+	switch k.strategy {
+	case StrategyRoundRobin:
+		if len(healthy) > 1 {
+			start := int(k.next.Add(1)-1) % len(healthy)
+			healthy = append(healthy[start:], healthy[:start]...)
+		}
+	case StrategyLeastLatency:
+		slices.SortStableFunc(healthy, func(a, b *endpoint) int {
+			return int(a.getLatency() - b.getLatency())
+		})
+	}
+
+	return append(healthy, down...)
+}
+
+// Check if the error is raised before the server answer the request
+func isConnectionError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var kErr *Error
+	if errors.As(err, &kErr) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// Check if the connection failed, so the request was never sent
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func isIdempotent(op string) bool {
+	return slices.Contains(idempotentOperations, op)
+}
@@ -0,0 +1,67 @@
+package ksema_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/suhailiealx/ksema-sdk-go"
+	"github.com/suhailiealx/ksema-sdk-go/ksematest"
+)
+
+// Start two fake servers sharing the same user
+func newClusterServers(t *testing.T) (*ksematest.Server, *ksematest.Server, *ksematest.User) {
+	t.Helper()
+	primary := newTestServer(t)
+	secondary := newTestServer(t)
+
+	user := primary.NewUser(ksematest.UserBrigade)
+	secondary.AddUser(ksematest.User{
+		PassKey: user.PassKey,
+		APIKey:  user.APIKey,
+		PIN:     user.PIN,
+		Type:    user.Type,
+	})
+	return primary, secondary, user
+}
+
+func TestFailover(t *testing.T) {
+	primary, secondary, user := newClusterServers(t)
+	ctx := context.Background()
+
+	k, err := ksema.NewCluster(ctx, []string{primary.Addr(), secondary.Addr()}, user.Credentials(),
+		ksema.WithRootCAs(primary.CertPool()),
+		ksema.WithHealthCheckInterval(time.Hour),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close(ctx)
+
+	var info ksema.CallInfo
+	if err := k.PingContext(ksema.WithCallInfo(ctx, &info)); err != nil {
+		t.Fatal(err)
+	}
+	if info.Endpoint != primary.Addr() {
+		t.Fatalf("served by %s, want the primary %s", info.Endpoint, primary.Addr())
+	}
+
+	primary.Close()
+
+	info = ksema.CallInfo{}
+	if err := k.PingContext(ksema.WithCallInfo(ctx, &info)); err != nil {
+		t.Fatalf("ping after the primary is down: %v", err)
+	}
+	if info.Endpoint != secondary.Addr() {
+		t.Fatalf("served by %s, want the secondary %s", info.Endpoint, secondary.Addr())
+	}
+
+	for _, status := range k.Endpoints() {
+		if status.Address == primary.Addr() && (status.Healthy || status.LastError == nil) {
+			t.Fatalf("primary is not marked down: %+v", status)
+		}
+	}
+	if state := k.Health().State; state != ksema.Degraded {
+		t.Fatalf("health is %s, want degraded", state)
+	}
+}
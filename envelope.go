@@ -0,0 +1,256 @@
+package ksema
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	ENVELOPE_VERSION = 1
+	// Size of the data encryption key, AES-256
+	DEK_SIZE = 32
+	// Maximum number of data keys kept in the cache
+	DEFAULT_DEK_CACHE_SIZE = 1024
+
+	envelopeAADPrefix = "ksema-envelope"
+)
+
+// ErrInvalidEnvelope is returned by Open when the envelope is malformed or tampered
+var ErrInvalidEnvelope = errors.New("invalid ksema envelope")
+
+// Envelope is the output of Seal
+// The data is encrypted locally with AES-GCM by the data key, which is encrypted by the key label
+type Envelope struct {
+	Version    int    `json:"version"`
+	Label      string `json:"label"`
+	WrappedKey []byte `json:"wrappedKey"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// WithDEKCache keep the unwrapped data keys for the duration to reduce the requests of Open
+// Disabled by default
+func WithDEKCache(ttl time.Duration) Option {
+	return func(c *config) {
+		c.dekCacheTTL = ttl
+	}
+}
+
+// Perform envelope encryption of the data
+// A new data key is generated by the server and encrypted by the key label, the data is encrypted locally
+// Return the JSON encoded envelope
+func (k *Ksema) Seal(plainText []byte, keyLabel string) ([]byte, error) {
+	return k.SealContext(context.Background(), plainText, keyLabel)
+}
+
+// SealContext is like Seal but bound to the context
+func (k *Ksema) SealContext(ctx context.Context, plainText []byte, keyLabel string) ([]byte, error) {
+	if k.getUserType() > USER_OBJECT && keyLabel == "" {
+		return nil, ErrNoKeyLabel
+	}
+
+	dek, err := k.RandomContext(ctx, DEK_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(dek)
+	if len(dek) != DEK_SIZE {
+		return nil, fmt.Errorf("random returned %d bytes instead of %d", len(dek), DEK_SIZE)
+	}
+
+	wrapped, err := k.EncryptContext(ctx, dek, keyLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newEnvelopeAEAD(dek)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := k.RandomContext(ctx, uint16(aead.NonceSize()))
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("random returned %d bytes instead of %d", len(nonce), aead.NonceSize())
+	}
+
+	env := Envelope{
+		Version:    ENVELOPE_VERSION,
+		Label:      keyLabel,
+		WrappedKey: wrapped,
+		Nonce:      nonce,
+	}
+	env.Ciphertext = aead.Seal(nil, nonce, plainText, env.aad())
+
+	return json.Marshal(env)
+}
+
+// Decrypt the envelope returned by Seal
+// The data key is decrypted by the server with the key label of the envelope
+func (k *Ksema) Open(envelope []byte) ([]byte, error) {
+	return k.OpenContext(context.Background(), envelope)
+}
+
+// OpenContext is like Open but bound to the context
+func (k *Ksema) OpenContext(ctx context.Context, envelope []byte) ([]byte, error) {
+	var env Envelope
+	if err := json.Unmarshal(envelope, &env); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEnvelope, err)
+	}
+	if env.Version != ENVELOPE_VERSION {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidEnvelope, env.Version)
+	}
+	if len(env.WrappedKey) == 0 {
+		return nil, fmt.Errorf("%w: missing wrapped key", ErrInvalidEnvelope)
+	}
+
+	dek, err := k.unwrapDEK(ctx, env.Label, env.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(dek)
+
+	aead, err := newEnvelopeAEAD(dek)
+	if err != nil {
+		return nil, err
+	}
+	if len(env.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("%w: invalid nonce size", ErrInvalidEnvelope)
+	}
+
+	plainText, err := aead.Open(nil, env.Nonce, env.Ciphertext, env.aad())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEnvelope, err)
+	}
+	return plainText, nil
+}
+
+// Decrypt the data key by the server, or return a copy from the cache
+func (k *Ksema) unwrapDEK(ctx context.Context, keyLabel string, wrapped []byte) ([]byte, error) {
+	if k.closed.Load() {
+		return nil, ErrClosed
+	}
+	if k.dekCache != nil {
+		if dek, ok := k.dekCache.get(keyLabel, wrapped); ok {
+			return dek, nil
+		}
+	}
+
+	dek, err := k.DecryptContext(ctx, wrapped, keyLabel)
+	if err != nil {
+		return nil, err
+	}
+	if len(dek) != DEK_SIZE {
+		clear(dek)
+		return nil, fmt.Errorf("%w: invalid data key size", ErrInvalidEnvelope)
+	}
+
+	if k.dekCache != nil {
+		k.dekCache.put(keyLabel, wrapped, dek)
+	}
+	return dek, nil
+}
+
+// Additional data of AES-GCM, bind the ciphertext to the version and the key label
+func (e *Envelope) aad() []byte {
+	aad := []byte(envelopeAADPrefix)
+	aad = binary.BigEndian.AppendUint16(aad, uint16(e.Version))
+	aad = binary.BigEndian.AppendUint32(aad, uint32(len(e.Label)))
+	return append(aad, e.Label...)
+}
+
+func newEnvelopeAEAD(dek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Cache of the unwrapped data keys, indexed by key label and wrapped key
+type dekCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]dekEntry
+}
+
+type dekEntry struct {
+	dek     []byte
+	expires time.Time
+}
+
+func newDEKCache(ttl time.Duration) *dekCache {
+	return &dekCache{
+		ttl:     ttl,
+		entries: make(map[string]dekEntry),
+	}
+}
+
+func dekCacheKey(keyLabel string, wrapped []byte) string {
+	return keyLabel + "\x00" + string(wrapped)
+}
+
+func (c *dekCache) get(keyLabel string, wrapped []byte) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := dekCacheKey(keyLabel, wrapped)
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expires) {
+		clear(e.dek)
+		delete(c.entries, key)
+		return nil, false
+	}
+	return bytes.Clone(e.dek), true
+}
+
+func (c *dekCache) put(keyLabel string, wrapped, dek []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= DEFAULT_DEK_CACHE_SIZE {
+		for key, e := range c.entries {
+			if now.After(e.expires) {
+				clear(e.dek)
+				delete(c.entries, key)
+			}
+		}
+	}
+	// Still full, drop any entry
+	for key, e := range c.entries {
+		if len(c.entries) < DEFAULT_DEK_CACHE_SIZE {
+			break
+		}
+		clear(e.dek)
+		delete(c.entries, key)
+	}
+
+	c.entries[dekCacheKey(keyLabel, wrapped)] = dekEntry{
+		dek:     bytes.Clone(dek),
+		expires: now.Add(c.ttl),
+	}
+}
+
+// Remove every data key from memory
+func (c *dekCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, e := range c.entries {
+		clear(e.dek)
+		delete(c.entries, key)
+	}
+}
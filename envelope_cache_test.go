@@ -0,0 +1,30 @@
+package ksema
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestDEKCachePurge(t *testing.T) {
+	c := newDEKCache(time.Minute)
+	dek := bytes.Repeat([]byte{1}, DEK_SIZE)
+	c.put("AES01", []byte("wrapped"), dek)
+
+	cached, ok := c.get("AES01", []byte("wrapped"))
+	if !ok || !bytes.Equal(cached, dek) {
+		t.Fatal("data key not cached")
+	}
+	if _, ok := c.get("AES02", []byte("wrapped")); ok {
+		t.Fatal("data key cached for another label")
+	}
+
+	stored := c.entries[dekCacheKey("AES01", []byte("wrapped"))].dek
+	c.purge()
+	if len(c.entries) != 0 {
+		t.Fatalf("%d entries left after purge", len(c.entries))
+	}
+	if !bytes.Equal(stored, make([]byte, DEK_SIZE)) {
+		t.Fatal("data key not cleared by purge")
+	}
+}
@@ -0,0 +1,149 @@
+package ksema_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/suhailiealx/ksema-sdk-go"
+	"github.com/suhailiealx/ksema-sdk-go/ksematest"
+)
+
+// Return a client counting its DECRYPT requests
+func newEnvelopeKsema(t *testing.T, opts ...ksema.Option) (*ksema.Ksema, *atomic.Int32) {
+	t.Helper()
+	srv := newTestServer(t)
+
+	decrypts := new(atomic.Int32)
+	count := func(ctx context.Context, req *ksema.ServiceRequest, invoke ksema.Invoker) (*ksema.ServiceResponse, error) {
+		if req.Operation == ksema.FunctionDecrypt {
+			decrypts.Add(1)
+		}
+		return invoke(ctx, req)
+	}
+	k := newTestClient(t, srv, ksematest.UserBrigade, append(opts, ksema.WithInterceptors(count))...)
+	genKey(t, k, "AES01", "")
+	genKey(t, k, "AES02", "")
+	return k, decrypts
+}
+
+func sealEnvelope(t *testing.T, k *ksema.Ksema, plainText []byte) ksema.Envelope {
+	t.Helper()
+	sealed, err := k.Seal(plainText, "AES01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var env ksema.Envelope
+	if err := json.Unmarshal(sealed, &env); err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func openEnvelope(k *ksema.Ksema, env ksema.Envelope) ([]byte, error) {
+	sealed, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	return k.Open(sealed)
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	k, _ := newEnvelopeKsema(t)
+
+	for _, plainText := range [][]byte{{}, []byte("data"), bytes.Repeat([]byte("data"), 10000)} {
+		env := sealEnvelope(t, k, plainText)
+		if env.Version != ksema.ENVELOPE_VERSION || env.Label != "AES01" {
+			t.Fatalf("got version %d and label %s", env.Version, env.Label)
+		}
+		got, err := openEnvelope(k, env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, plainText) {
+			t.Fatalf("opened %d bytes, want %d", len(got), len(plainText))
+		}
+	}
+}
+
+func TestEnvelopeTampering(t *testing.T) {
+	k, _ := newEnvelopeKsema(t)
+	env := sealEnvelope(t, k, []byte("data"))
+
+	// Same data key wrapped by another label, only the additional data bind the label
+	dek, err := k.Decrypt(env.WrappedKey, "AES01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewrapped, err := k.Encrypt(dek, "AES02")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flip := func(b []byte) []byte {
+		b = bytes.Clone(b)
+		b[0] ^= 1
+		return b
+	}
+	tests := []struct {
+		name   string
+		tamper func(*ksema.Envelope)
+	}{
+		{"label", func(e *ksema.Envelope) { e.Label, e.WrappedKey = "AES02", rewrapped }},
+		{"nonce", func(e *ksema.Envelope) { e.Nonce = flip(e.Nonce) }},
+		{"nonce size", func(e *ksema.Envelope) { e.Nonce = e.Nonce[1:] }},
+		{"ciphertext", func(e *ksema.Envelope) { e.Ciphertext = flip(e.Ciphertext) }},
+		{"truncated ciphertext", func(e *ksema.Envelope) { e.Ciphertext = e.Ciphertext[:len(e.Ciphertext)-1] }},
+		{"version", func(e *ksema.Envelope) { e.Version = ksema.ENVELOPE_VERSION + 1 }},
+		{"missing wrapped key", func(e *ksema.Envelope) { e.WrappedKey = nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := env
+			tt.tamper(&tampered)
+			if _, err := openEnvelope(k, tampered); !errors.Is(err, ksema.ErrInvalidEnvelope) {
+				t.Fatalf("got %v, want ErrInvalidEnvelope", err)
+			}
+		})
+	}
+
+	if _, err := k.Open([]byte("not json")); !errors.Is(err, ksema.ErrInvalidEnvelope) {
+		t.Fatalf("malformed envelope: got %v, want ErrInvalidEnvelope", err)
+	}
+}
+
+func TestEnvelopeDEKCache(t *testing.T) {
+	k, decrypts := newEnvelopeKsema(t, ksema.WithDEKCache(100*time.Millisecond))
+	env := sealEnvelope(t, k, []byte("data"))
+
+	// The second open use the cached data key
+	for range 2 {
+		if _, err := openEnvelope(k, env); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := decrypts.Load(); n != 1 {
+		t.Fatalf("got %d decrypt requests, want 1", n)
+	}
+
+	// The data key expired
+	time.Sleep(150 * time.Millisecond)
+	if _, err := openEnvelope(k, env); err != nil {
+		t.Fatal(err)
+	}
+	if n := decrypts.Load(); n != 2 {
+		t.Fatalf("got %d decrypt requests after the TTL, want 2", n)
+	}
+
+	// The cached data key can not be used after Close
+	if err := k.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openEnvelope(k, env); !errors.Is(err, ksema.ErrClosed) {
+		t.Fatalf("open after close: got %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,85 @@
+package ksema
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Sentinel errors for the return code of the server
+// Use errors.Is to check the returned error
+var (
+	ErrFailed           = errors.New(mapRetCodeToString[FAILED])
+	ErrNoLabelFound     = errors.New(mapRetCodeToString[NOLABELFOUND])
+	ErrMaxUsage         = errors.New(mapRetCodeToString[MAXUSAGE])
+	ErrUnauthorized     = errors.New(mapRetCodeToString[UNAUTHORIZEDFUNC])
+	ErrInvalidPacket    = errors.New(mapRetCodeToString[INVALIDPACKET])
+	ErrKeyExisted       = errors.New(mapRetCodeToString[KEYEXISTED])
+	ErrPINIncorrect     = errors.New(mapRetCodeToString[PININCORRECT])
+	ErrPINLocked        = errors.New(mapRetCodeToString[PINLOCKED])
+	ErrSessionInvalid   = errors.New(mapRetCodeToString[SESSIONINVALID])
+	ErrInvalidEncrypted = errors.New(mapRetCodeToString[INVALIDENCRYPTED])
+)
+
+// ErrNoKeyLabel is returned when user slot does not specify the key label
+var ErrNoKeyLabel = errors.New("no key label specified")
+
+// ErrClosed is returned when the Ksema object is used after Close
+var ErrClosed = errors.New("ksema is closed")
+
+var mapRetCodeToError map[int]error = map[int]error{
+	FAILED:           ErrFailed,
+	NOLABELFOUND:     ErrNoLabelFound,
+	MAXUSAGE:         ErrMaxUsage,
+	UNAUTHORIZEDFUNC: ErrUnauthorized,
+	INVALIDPACKET:    ErrInvalidPacket,
+	KEYEXISTED:       ErrKeyExisted,
+	PININCORRECT:     ErrPINIncorrect,
+	PINLOCKED:        ErrPINLocked,
+	SESSIONINVALID:   ErrSessionInvalid,
+	INVALIDENCRYPTED: ErrInvalidEncrypted,
+}
+
+// Error is returned when the server reject a request
+type Error struct {
+	// Operation requested, e.g. ENCRYPT
+	Op string
+	// Return code of the server
+	RetCode int
+	// Key label of the request, if any
+	Label string
+	// Error message returned by the server, if any
+	ErrorMsg string
+	// HTTP status code of the response
+	HTTPStatus int
+}
+
+func (e *Error) Error() string {
+	var sb strings.Builder
+
+	sb.WriteString(e.Op)
+	if e.Label != "" {
+		sb.WriteString(" ")
+		sb.WriteString(e.Label)
+	}
+	sb.WriteString(": ")
+
+	if msg, exists := mapRetCodeToString[e.RetCode]; exists {
+		sb.WriteString(msg)
+	} else {
+		fmt.Fprintf(&sb, "Unknown return %d", e.RetCode)
+	}
+	if e.ErrorMsg != "" {
+		sb.WriteString(" (")
+		sb.WriteString(e.ErrorMsg)
+		sb.WriteString(")")
+	}
+
+	return sb.String()
+}
+
+// Unwrap return the sentinel error of the return code
+// Return nil if the return code is unknown
+func (e *Error) Unwrap() error {
+	return mapRetCodeToError[e.RetCode]
+}
@@ -1,3 +1,5 @@
 module github.com/suhailiealx/ksema-sdk-go
 
 go 1.24.0
+
+require golang.org/x/crypto v0.48.0
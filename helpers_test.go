@@ -0,0 +1,36 @@
+package ksema_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/suhailiealx/ksema-sdk-go"
+	"github.com/suhailiealx/ksema-sdk-go/ksematest"
+)
+
+// Start a fake server, closed at the end of the test
+func newTestServer(t *testing.T) *ksematest.Server {
+	t.Helper()
+	srv := ksematest.NewServer()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// Connect a new user of the type to the server, the client is closed at the end of the test
+func newTestClient(t *testing.T, srv *ksematest.Server, userType int, opts ...ksema.Option) *ksema.Ksema {
+	t.Helper()
+	k, err := srv.NewKsema(userType, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { k.Close(context.Background()) })
+	return k
+}
+
+// Generate the key, or the keypair if both labels are given
+func genKey(t *testing.T, k *ksema.Ksema, label1, label2 string) {
+	t.Helper()
+	if err := k.GenKey(label1, label2); err != nil {
+		t.Fatal(err)
+	}
+}
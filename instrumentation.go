@@ -0,0 +1,80 @@
+package ksema
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// RequestInfo describe a request sent to the server
+type RequestInfo struct {
+	// Operation requested, e.g. ENCRYPT
+	Op string
+	// Key label of the request, if any
+	Label string
+	// User type of the session
+	UserType int
+	// Size of the request data in bytes
+	RequestSize int
+}
+
+// ResultInfo describe the result of a request
+type ResultInfo struct {
+	// Address of the endpoint which served the last attempt
+	Endpoint string
+	// Number of attempts made
+	Attempts int
+	// Return code of the server, FAILED if the server does not answer
+	RetCode int
+	// HTTP status of the rejected response, zero otherwise
+	HTTPStatus int
+	// Size of the response data in bytes
+	ResponseSize int
+	// Time taken by the request including retries
+	Duration time.Duration
+	// Error of the request, nil if success
+	Err error
+}
+
+// Instrumentation observe every request sent to the server, e.g. for tracing and metrics
+//
+// See package otelksema for the OpenTelemetry implementation
+type Instrumentation interface {
+	// Start is called before the request is sent
+	// Return the context used for the request
+	Start(ctx context.Context, info RequestInfo) context.Context
+	// Inject add the trace context to the headers of the outgoing HTTP request
+	Inject(ctx context.Context, header http.Header)
+	// End is called after the request is finished
+	End(ctx context.Context, info RequestInfo, result ResultInfo)
+}
+
+// WithInstrumentation set the instrumentation of every request
+func WithInstrumentation(instrumentation Instrumentation) Option {
+	return func(c *config) {
+		c.instrumentation = instrumentation
+	}
+}
+
+// Build the result info of a request
+func newResultInfo(info *CallInfo, duration time.Duration, res *ServiceResponse, err error) ResultInfo {
+	result := ResultInfo{
+		Endpoint: info.Endpoint,
+		Attempts: info.Attempts,
+		RetCode:  retCodeOf(err),
+		Duration: duration,
+		Err:      err,
+	}
+	if res != nil {
+		result.ResponseSize = base64.StdEncoding.DecodedLen(len(res.Data.Message))
+	}
+
+	var kErr *Error
+	if errors.As(err, &kErr) {
+		result.HTTPStatus = kErr.HTTPStatus
+	}
+
+	return result
+}
@@ -0,0 +1,33 @@
+package ksema
+
+import "context"
+
+// Invoker send the request to the server
+type Invoker func(ctx context.Context, req *ServiceRequest) (*ServiceResponse, error)
+
+// Interceptor is called for every request sent to the server
+//
+// It can inspect or modify the request and response, time the call,
+// or short-circuit it by returning without calling invoke
+// The session ID of the request is not available, it is filled after the interceptors
+// A short-circuited response which is not success is returned to the caller as *Error
+type Interceptor func(ctx context.Context, req *ServiceRequest, invoke Invoker) (*ServiceResponse, error)
+
+// WithInterceptors add interceptors to every request
+// The first interceptor is the outermost one
+func WithInterceptors(interceptors ...Interceptor) Option {
+	return func(c *config) {
+		c.interceptors = append(c.interceptors, interceptors...)
+	}
+}
+
+// Chain the interceptors in front of the invoker
+func chainInterceptors(interceptors []Interceptor, invoker Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, req *ServiceRequest) (*ServiceResponse, error) {
+			return interceptor(ctx, req, next)
+		}
+	}
+	return invoker
+}
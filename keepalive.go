@@ -0,0 +1,202 @@
+package ksema
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// HealthState is the overall health of the endpoints
+type HealthState int
+
+const (
+	// Every endpoint is healthy
+	Healthy HealthState = iota
+	// Some endpoints are down
+	Degraded
+	// Every endpoint is down
+	Unhealthy
+)
+
+func (s HealthState) String() string {
+	switch s {
+	case Healthy:
+		return "healthy"
+	case Degraded:
+		return "degraded"
+	case Unhealthy:
+		return "unhealthy"
+	}
+	return "unknown"
+}
+
+// Health report the health of the client
+type Health struct {
+	State     HealthState
+	Endpoints []EndpointStatus
+}
+
+// WithKeepAlive ping every endpoint on the interval to keep the session alive
+// The session is re-authenticated when the ping failed or the session is close to expiry (see WithSessionTTL)
+func WithKeepAlive(interval time.Duration) Option {
+	return func(c *config) {
+		c.keepAlive = interval
+	}
+}
+
+// WithSessionTTL set the lifetime of a session on the server
+// With keep alive, the session is re-authenticated before it expired
+func WithSessionTTL(ttl time.Duration) Option {
+	return func(c *config) {
+		c.sessionTTL = ttl
+	}
+}
+
+// Return the interval of the background ping, zero if disabled
+func (c *config) monitorInterval() time.Duration {
+	var interval time.Duration
+	if c.healthCheckInterval != nil {
+		interval = *c.healthCheckInterval
+	}
+	if c.keepAlive > 0 && (interval <= 0 || c.keepAlive < interval) {
+		interval = c.keepAlive
+	}
+	return interval
+}
+
+// Health return the current health of the client
+func (k *Ksema) Health() Health {
+	k.healthMu.Lock()
+	defer k.healthMu.Unlock()
+	return k.health
+}
+
+// HealthChanges return a channel which receive the health when its state changed
+//
+// Only the latest health is kept if it is not received in time
+// The channel is closed by Close
+func (k *Ksema) HealthChanges() <-chan Health {
+	return k.healthCh
+}
+
+func (k *Ksema) computeHealth() Health {
+	health := Health{
+		State:     Healthy,
+		Endpoints: k.Endpoints(),
+	}
+
+	down := 0
+	for _, ep := range health.Endpoints {
+		if !ep.Healthy {
+			down++
+		}
+	}
+	switch {
+	case down == len(health.Endpoints):
+		health.State = Unhealthy
+	case down > 0:
+		health.State = Degraded
+	}
+
+	return health
+}
+
+// Update the health and notify if its state changed
+func (k *Ksema) updateHealth() {
+	health := k.computeHealth()
+
+	k.healthMu.Lock()
+	defer k.healthMu.Unlock()
+
+	changed := health.State != k.health.State
+	k.health = health
+	if !changed || k.closed.Load() {
+		return
+	}
+
+	// Replace the pending notification with the latest one
+	select {
+	case <-k.healthCh:
+	default:
+	}
+	k.healthCh <- health
+}
+
+// Ping every endpoint periodically until stopped
+func (k *Ksema) healthCheck(interval time.Duration) {
+	defer k.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-k.stop:
+			return
+		case <-ticker.C:
+		}
+
+		k.checkEndpoints(interval)
+		k.updateHealth()
+	}
+}
+
+// Check every endpoint in parallel, so a tick take at most the interval
+// The checks are canceled when the client is closed
+func (k *Ksema) checkEndpoints(interval time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), interval)
+	defer cancel()
+	go func() {
+		select {
+		case <-k.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, ep := range k.endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			k.checkEndpoint(ctx, ep, interval)
+		}()
+	}
+	wg.Wait()
+}
+
+// Ping the endpoint, with keep alive the session is renewed if needed
+func (k *Ksema) checkEndpoint(ctx context.Context, ep *endpoint, interval time.Duration) {
+	if k.keepAlive && k.sessionTTL > 0 && ep.sessionAge() > k.sessionTTL-interval {
+		stale := ep.sessionID()
+		if err := k.reauth(ctx, ep, FunctionPing, stale); err != nil {
+			ep.markDown(err, k.coolOff)
+			k.logger.Warn("ksema session renewal failed", "endpoint", ep.address, "error", err.Error())
+			return
+		}
+		// The old session is still valid on the server, end it so the renewals do not pile up sessions
+		if stale != "" && ep.sessionID() != stale {
+			k.endSession(ctx, ep.address, stale)
+		}
+	}
+
+	start := time.Now()
+	_, err := k.send(ctx, ep, ServiceRequest{Operation: FunctionPing})
+	if err != nil && k.keepAlive && !isConnectionError(err) {
+		if k.reauth(ctx, ep, FunctionPing, ep.sessionID()) == nil {
+			start = time.Now()
+			_, err = k.send(ctx, ep, ServiceRequest{Operation: FunctionPing})
+		}
+	}
+
+	ep.mu.Lock()
+	ep.lastPing = time.Now()
+	ep.mu.Unlock()
+
+	if err != nil {
+		ep.markDown(err, k.coolOff)
+		k.logger.Warn("ksema health check failed", "endpoint", ep.address, "error", err.Error())
+	} else {
+		ep.markUp(time.Since(start))
+	}
+}
@@ -0,0 +1,104 @@
+package ksema_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/suhailiealx/ksema-sdk-go"
+	"github.com/suhailiealx/ksema-sdk-go/ksematest"
+)
+
+// Wait until the condition is true or fail the test
+func eventually(t *testing.T, timeout time.Duration, cond func() bool, msg string) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHealthChanges(t *testing.T) {
+	primary, secondary, user := newClusterServers(t)
+	ctx := context.Background()
+
+	k, err := ksema.NewCluster(ctx, []string{primary.Addr(), secondary.Addr()}, user.Credentials(),
+		ksema.WithRootCAs(primary.CertPool()),
+		ksema.WithHealthCheckInterval(20*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state := k.Health().State; state != ksema.Healthy {
+		t.Fatalf("health is %s, want healthy", state)
+	}
+
+	primary.Close()
+
+	select {
+	case health := <-k.HealthChanges():
+		if health.State != ksema.Degraded {
+			t.Fatalf("health changed to %s, want degraded", health.State)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("health change not notified")
+	}
+
+	// The logout of the closed primary fails
+	k.Close(ctx)
+	if _, ok := <-k.HealthChanges(); ok {
+		t.Fatal("health channel not closed by Close")
+	}
+}
+
+func TestKeepAliveReauth(t *testing.T) {
+	srv := newTestServer(t)
+
+	var events atomic.Int32
+	k := newTestClient(t, srv, ksematest.UserBrigade,
+		ksema.WithKeepAlive(20*time.Millisecond),
+		ksema.WithReauthHook(func(e ksema.ReauthEvent) {
+			if e.Err == nil {
+				events.Add(1)
+			}
+		}),
+	)
+
+	srv.ExpireSessions()
+	eventually(t, 5*time.Second, func() bool {
+		return events.Load() > 0 && srv.Sessions() == 1
+	}, "keep alive did not re-authenticate the expired session")
+
+	if state := k.Health().State; state != ksema.Healthy {
+		t.Fatalf("health is %s, want healthy", state)
+	}
+}
+
+func TestKeepAliveSessionTTL(t *testing.T) {
+	srv := newTestServer(t)
+
+	var events atomic.Int32
+	newTestClient(t, srv, ksematest.UserBrigade,
+		ksema.WithKeepAlive(20*time.Millisecond),
+		ksema.WithSessionTTL(60*time.Millisecond),
+		ksema.WithReauthHook(func(e ksema.ReauthEvent) {
+			if e.Err == nil {
+				events.Add(1)
+			}
+		}),
+	)
+
+	eventually(t, 5*time.Second, func() bool {
+		return events.Load() >= 2
+	}, "keep alive did not renew the session before its TTL")
+
+	// The renewed sessions are ended on the server
+	eventually(t, 5*time.Second, func() bool {
+		return srv.Sessions() == 1
+	}, "renewed sessions not ended on the server")
+}
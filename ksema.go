@@ -2,96 +2,256 @@ package ksema
 
 import (
 	"bytes"
-	"crypto/tls"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"log/slog"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// Operation name used in error of the key exchange
+const opAuth = "AUTH"
+
 type Ksema struct {
-	serverIP string
-	passKey  string
-	apiKey   string
-	pin      string
-	client   *http.Client
-	sessID   string
+	endpoints  []*endpoint
+	client     *http.Client
+	reauthHook func(ReauthEvent)
+	retry      RetryPolicy
+	strategy   Strategy
+	coolOff    time.Duration
+	logger     *slog.Logger
+
+	// Observe every request, may be nil
+	instrumentation Instrumentation
+
+	// Interceptors chained in front of the retry
+	interceptors []Interceptor
+	invoker      Invoker
+
+	// Rate limit and usage counter, may be nil
+	limiter *limiter
+
+	// Number of concurrent requests of the batch operations
+	batchConcurrency int
+	// Plaintext size of each chunk of the encrypt writer
+	streamChunkSize int
+	// Unwrapped data keys of the envelopes, nil if disabled
+	dekCache *dekCache
+
+	// Counter of the round robin strategy
+	next atomic.Uint64
+
+	// Stop the background goroutines
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	// Health of the endpoints, see Health
+	keepAlive  bool
+	sessionTTL time.Duration
+	healthMu   sync.Mutex
+	health     Health
+	healthCh   chan Health
+
+	// Set once Close is called
+	closed    atomic.Bool
+	closeOnce sync.Once
+	closeErr  error
+
+	// Guard the state below
+	mu       sync.RWMutex
+	creds    Credentials
 	userType int
 }
 
 // New return the pointer of Ksema object
 //
 // It automatically execute the key exchange and must be success in order to use it
+// The server certificate is not verified, use NewWithOptions to enable verification
 func New(serverIP, passKey, apiKey, pin string) (*Ksema, error) {
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-				CurvePreferences: []tls.CurveID{
-					tls.X25519MLKEM768,
-				},
-			},
-		},
+	creds := Credentials{
+		PassKey: passKey,
+		APIKey:  apiKey,
+		PIN:     pin,
+	}
+	return NewWithOptions(serverIP, creds, WithInsecureSkipVerify())
+}
+
+// NewWithOptions return the pointer of Ksema object configured with the given options
+//
+// The server certificate is verified by default
+// It automatically execute the key exchange and must be success in order to use it
+func NewWithOptions(serverIP string, creds Credentials, opts ...Option) (*Ksema, error) {
+	return NewContext(context.Background(), serverIP, creds, opts...)
+}
+
+// NewContext is like NewWithOptions but the key exchange is bound to the context
+func NewContext(ctx context.Context, serverIP string, creds Credentials, opts ...Option) (*Ksema, error) {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(cfg)
 	}
 
+	return newKsema(ctx, []string{serverIP}, creds, cfg)
+}
+
+func newKsema(ctx context.Context, addresses []string, creds Credentials, cfg *config) (*Ksema, error) {
 	k := &Ksema{
-		serverIP: serverIP,
-		passKey:  passKey,
-		apiKey:   apiKey,
-		pin:      pin,
-		client:   client,
-	}
+		client:          cfg.newHTTPClient(),
+		reauthHook:      cfg.reauthHook,
+		retry:           cfg.retry,
+		strategy:        cfg.strategy,
+		coolOff:         cfg.coolOff,
+		logger:          cfg.logger,
+		instrumentation: cfg.instrumentation,
+		stop:            make(chan struct{}),
+		creds:           creds,
+		keepAlive:       cfg.keepAlive > 0,
+		sessionTTL:      cfg.sessionTTL,
+		healthCh:        make(chan Health, 1),
 
-	if success, err := k.keyExchange(); err != nil || !success {
-		fmt.Println("Key exchange failed, please retry")
+		batchConcurrency: max(cfg.batchConcurrency, 1),
+		streamChunkSize:  min(max(cfg.streamChunkSize, 1), MAX_STREAM_CHUNK_SIZE),
+	}
+	limiter, err := newLimiter(cfg)
+	if err != nil {
 		return nil, err
 	}
+	k.interceptors = cfg.interceptors
+	if limiter != nil {
+		k.limiter = limiter
+		k.interceptors = append(k.interceptors, limiter.intercept)
+	}
+	k.invoker = chainInterceptors(k.interceptors, k.requestRetry)
+	if cfg.dekCacheTTL > 0 {
+		k.dekCache = newDEKCache(cfg.dekCacheTTL)
+	}
+	for _, address := range addresses {
+		ep := &endpoint{address: address}
+		if cfg.breaker != nil {
+			ep.breaker = newBreaker(*cfg.breaker, address)
+		}
+		k.endpoints = append(k.endpoints, ep)
+	}
+
+	var errs []error
+	for _, ep := range k.endpoints {
+		if _, err := k.keyExchange(ctx, ep); err != nil {
+			k.logger.WarnContext(ctx, "ksema key exchange failed", "endpoint", ep.address, "error", err.Error())
+			ep.markDown(err, k.coolOff)
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == len(k.endpoints) {
+		return nil, errors.Join(errs...)
+	}
+
+	k.health = k.computeHealth()
+	if interval := cfg.monitorInterval(); interval > 0 {
+		k.wg.Add(1)
+		go k.healthCheck(interval)
+	}
+	if k.limiter != nil && k.limiter.store != nil {
+		k.wg.Add(1)
+		go k.flushUsage()
+	}
 
 	return k, nil
 }
 
+// Return the return code of a failed authentication
+// Use the return code of the response if any, otherwise the error message or the HTTP status
+func authRetCode(res *AuthResponse, status int) int {
+	if res.Data.RetCode != 0 && res.Data.RetCode != SUCCESS {
+		return res.Data.RetCode
+	}
+	for retCode, msg := range mapRetCodeToString {
+		if retCode != SUCCESS && retCode != FAILED && strings.EqualFold(strings.TrimSpace(res.ErrorMsg), msg) {
+			return retCode
+		}
+	}
+	if status == http.StatusLocked {
+		return PINLOCKED
+	}
+	return FAILED
+}
+
 // Perform key exchange with three-way encrypted handshake
-func (k *Ksema) keyExchange() (bool, error) {
+func (k *Ksema) keyExchange(ctx context.Context, ep *endpoint) (bool, error) {
 	var res AuthResponse
 
+	if k.closed.Load() {
+		return false, ErrClosed
+	}
+
+	k.mu.RLock()
 	payload := AuthRequest{
-		Passkey: k.passKey,
-		APIKey:  k.apiKey,
-		PIN:     k.pin,
+		Passkey: k.creds.PassKey,
+		APIKey:  k.creds.APIKey,
+		PIN:     k.creds.PIN,
 	}
+	k.mu.RUnlock()
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
-		fmt.Printf("Error marshaling JSON: %v\n", err)
-		return false, err
+		return false, fmt.Errorf("marshaling request: %w", err)
 	}
 
-	resp, err := k.client.Post(fmt.Sprintf("https://%s/api/hsm/auth", k.serverIP), "application/json", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("https://%s/api/hsm/auth", ep.address), bytes.NewBuffer(jsonData))
 	if err != nil {
-		fmt.Printf("Error making GET request: %v\n", err)
-		return false, err
+		return false, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if k.instrumentation != nil {
+		k.instrumentation.Inject(ctx, req.Header)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("making POST request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Read and print response
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Printf("Error reading response body: %v\n", err)
-		return false, err
+		return false, fmt.Errorf("reading response body: %w", err)
 	}
 
 	err = json.Unmarshal(body, &res)
 	if err != nil {
-		fmt.Printf("Error unmarshaling response: %v\n", err)
-		return false, err
+		if resp.StatusCode/100 != 2 {
+			return false, &Error{
+				Op:         opAuth,
+				RetCode:    authRetCode(&res, resp.StatusCode),
+				ErrorMsg:   http.StatusText(resp.StatusCode),
+				HTTPStatus: resp.StatusCode,
+			}
+		}
+		return false, fmt.Errorf("unmarshaling response: %w", err)
 	}
 
 	if !res.Success {
-		return false, errors.New("return auth request is false")
+		return false, &Error{
+			Op:         opAuth,
+			RetCode:    authRetCode(&res, resp.StatusCode),
+			ErrorMsg:   res.ErrorMsg,
+			HTTPStatus: resp.StatusCode,
+		}
 	}
 
-	k.sessID = res.Data.SessionID
+	ep.mu.Lock()
+	ep.sessID = res.Data.SessionID
+	ep.authAt = time.Now()
+	ep.mu.Unlock()
+
+	k.mu.Lock()
 	k.userType = res.Data.UserType
+	k.mu.Unlock()
 
 	return true, nil
 }
@@ -99,7 +259,12 @@ func (k *Ksema) keyExchange() (bool, error) {
 // Perform ping to server
 // Return error if failed
 func (k *Ksema) Ping() error {
-	return operationPing(k.client, k.sessID, k.serverIP)
+	return k.PingContext(context.Background())
+}
+
+// PingContext is like Ping but bound to the context
+func (k *Ksema) PingContext(ctx context.Context) error {
+	return operationPing(ctx, k.request)
 }
 
 // Perform encrypt of a data bytes
@@ -107,10 +272,15 @@ func (k *Ksema) Ping() error {
 //
 // User object does not need to specified the key label used, except for user slot
 func (k *Ksema) Encrypt(data []byte, keyLabel string) ([]byte, error) {
-	if k.userType > USER_OBJECT && keyLabel == "" {
-		return nil, errors.New("no key label specified")
+	return k.EncryptContext(context.Background(), data, keyLabel)
+}
+
+// EncryptContext is like Encrypt but bound to the context
+func (k *Ksema) EncryptContext(ctx context.Context, data []byte, keyLabel string) ([]byte, error) {
+	if k.getUserType() > USER_OBJECT && keyLabel == "" {
+		return nil, ErrNoKeyLabel
 	}
-	return operationEncrypt(k.client, k.sessID, k.serverIP, data, keyLabel)
+	return operationEncrypt(ctx, k.request, data, keyLabel)
 }
 
 // Perform decrypt of a data bytes
@@ -118,10 +288,15 @@ func (k *Ksema) Encrypt(data []byte, keyLabel string) ([]byte, error) {
 //
 // User object does not need to specified the key label used, except for user slot
 func (k *Ksema) Decrypt(data []byte, keyLabel string) ([]byte, error) {
-	if k.userType > USER_OBJECT && keyLabel == "" {
-		return nil, errors.New("no key label specified")
+	return k.DecryptContext(context.Background(), data, keyLabel)
+}
+
+// DecryptContext is like Decrypt but bound to the context
+func (k *Ksema) DecryptContext(ctx context.Context, data []byte, keyLabel string) ([]byte, error) {
+	if k.getUserType() > USER_OBJECT && keyLabel == "" {
+		return nil, ErrNoKeyLabel
 	}
-	return operationDecrypt(k.client, k.sessID, k.serverIP, data, keyLabel)
+	return operationDecrypt(ctx, k.request, data, keyLabel)
 }
 
 // Perform signing of a data bytes
@@ -129,10 +304,15 @@ func (k *Ksema) Decrypt(data []byte, keyLabel string) ([]byte, error) {
 //
 // User object does not need to specified the key label used, except for user slot
 func (k *Ksema) Sign(data []byte, keyLabel string) ([]byte, error) {
-	if k.userType > USER_OBJECT && keyLabel == "" {
-		return nil, errors.New("no key label specified")
+	return k.SignContext(context.Background(), data, keyLabel)
+}
+
+// SignContext is like Sign but bound to the context
+func (k *Ksema) SignContext(ctx context.Context, data []byte, keyLabel string) ([]byte, error) {
+	if k.getUserType() > USER_OBJECT && keyLabel == "" {
+		return nil, ErrNoKeyLabel
 	}
-	return operationSign(k.client, k.sessID, k.serverIP, data, keyLabel)
+	return operationSign(ctx, k.request, data, keyLabel)
 }
 
 // Perform verifying of a data bytes with signature
@@ -140,10 +320,15 @@ func (k *Ksema) Sign(data []byte, keyLabel string) ([]byte, error) {
 //
 // User object does not need to specified the key label used, except for user slot
 func (k *Ksema) Verify(data, signature []byte, keyLabel string) error {
-	if k.userType > USER_OBJECT && keyLabel == "" {
-		return errors.New("no key label specified")
+	return k.VerifyContext(context.Background(), data, signature, keyLabel)
+}
+
+// VerifyContext is like Verify but bound to the context
+func (k *Ksema) VerifyContext(ctx context.Context, data, signature []byte, keyLabel string) error {
+	if k.getUserType() > USER_OBJECT && keyLabel == "" {
+		return ErrNoKeyLabel
 	}
-	return operationVerify(k.client, k.sessID, k.serverIP, data, signature, keyLabel)
+	return operationVerify(ctx, k.request, data, signature, keyLabel)
 }
 
 // Generate random data in bytes
@@ -151,6 +336,11 @@ func (k *Ksema) Verify(data, signature []byte, keyLabel string) error {
 //
 // if the length specified is 0, it will use the default length which is 32
 func (k *Ksema) Random(lenRandom uint16) ([]byte, error) {
+	return k.RandomContext(context.Background(), lenRandom)
+}
+
+// RandomContext is like Random but bound to the context
+func (k *Ksema) RandomContext(ctx context.Context, lenRandom uint16) ([]byte, error) {
 	var lengthBytes []byte
 
 	if lenRandom > 0 {
@@ -159,33 +349,59 @@ func (k *Ksema) Random(lenRandom uint16) ([]byte, error) {
 		lengthBytes = nil
 	}
 
-	return operationRNG(k.client, k.sessID, k.serverIP, lengthBytes)
+	return operationRNG(ctx, k.request, lengthBytes)
 }
 
 // Perform backup of a keylabel
 // Return error if it is not success
 //
+// The backup is written to the file, readable only by the owner
+// Use WithPassphrase to encrypt the exported keys with a passphrase
 // User object does not need to specified the key label used, except for user slot
-func (k *Ksema) Backup(fileName, keyLabel string) error {
-	if k.userType > USER_OBJECT && keyLabel == "" {
-		return errors.New("no key label specified")
+func (k *Ksema) Backup(fileName, keyLabel string, opts ...BackupOption) error {
+	return k.BackupContext(context.Background(), fileName, keyLabel, opts...)
+}
+
+// BackupContext is like Backup but bound to the context
+func (k *Ksema) BackupContext(ctx context.Context, fileName, keyLabel string, opts ...BackupOption) error {
+	backup, err := k.BackupKey(ctx, keyLabel)
+	if err != nil {
+		return err
 	}
-	return operationBackup(k.client, k.sessID, k.serverIP, k.userType, []byte(fileName))
+	return backup.WriteFile(fileName, opts...)
 }
 
 // Perform restore of a keylabel using the backed-up file
 // Return error if it is not success
-func (k *Ksema) Restore(fileName string) error {
-	return operationRestore(k.client, k.sessID, k.serverIP, []byte(fileName))
+//
+// WithPassphrase is required if the backup is protected by a passphrase
+// The legacy keypair of user object is restored with its private key file (see LegacyPrivateFile)
+// A key with the same label return *LabelConflictError, unless WithOverwrite is used
+func (k *Ksema) Restore(fileName string, opts ...BackupOption) error {
+	return k.RestoreContext(context.Background(), fileName, opts...)
+}
+
+// RestoreContext is like Restore but bound to the context
+func (k *Ksema) RestoreContext(ctx context.Context, fileName string, opts ...BackupOption) error {
+	backup, err := readBackupFile(fileName, opts...)
+	if err != nil {
+		return err
+	}
+	return k.RestoreBlob(ctx, backup, opts...)
 }
 
 // Perform deletion of a keylabel
 // Return error if it is not success
 func (k *Ksema) Delete(keyLabel string) error {
-	if k.userType > USER_OBJECT && keyLabel == "" {
-		return errors.New("no key label specified")
+	return k.DeleteContext(context.Background(), keyLabel)
+}
+
+// DeleteContext is like Delete but bound to the context
+func (k *Ksema) DeleteContext(ctx context.Context, keyLabel string) error {
+	if k.getUserType() > USER_OBJECT && keyLabel == "" {
+		return ErrNoKeyLabel
 	}
-	return operationDelete(k.client, k.sessID, k.serverIP, keyLabel)
+	return operationDelete(ctx, k.request, keyLabel)
 }
 
 // Generate key with the specified key label
@@ -194,30 +410,109 @@ func (k *Ksema) Delete(keyLabel string) error {
 //
 // Note that user object is not authorized to use this function
 func (k *Ksema) GenKey(label1, label2 string) error {
+	return k.GenKeyContext(context.Background(), label1, label2)
+}
+
+// GenKeyContext is like GenKey but bound to the context
+func (k *Ksema) GenKeyContext(ctx context.Context, label1, label2 string) error {
 	if label2 != "" {
-		return k.genKeyAsym(label1, label2)
+		return k.genKeyAsym(ctx, label1, label2)
 	}
-	return k.genKeySym(label1)
+	return k.genKeySym(ctx, label1)
 }
 
-func (k *Ksema) genKeySym(label string) error {
-	return operationGenKeySym(k.client, k.sessID, k.serverIP, label)
+func (k *Ksema) genKeySym(ctx context.Context, label string) error {
+	return operationGenKeySym(ctx, k.request, label)
 }
 
-func (k *Ksema) genKeyAsym(pubLabel, privLabel string) error {
+func (k *Ksema) genKeyAsym(ctx context.Context, pubLabel, privLabel string) error {
 	// label := fmt.Sprintf("%s;%s", pubLabel, privLabel)
-	return operationGenKeyAsym(k.client, k.sessID, k.serverIP, pubLabel, privLabel)
+	return operationGenKeyAsym(ctx, k.request, pubLabel, privLabel)
 }
 
 // Override the default IV temporarily
 // This effect will be remove if there is new session
 func (k *Ksema) SetIV(iv string) error {
+	return k.SetIVContext(context.Background(), iv)
+}
+
+// SetIVContext is like SetIV but bound to the context
+func (k *Ksema) SetIVContext(ctx context.Context, iv string) error {
 	if len(iv) != 16 {
 		return errors.New("IV must be 16 characters")
 	}
-	return operationSetIV(k.client, k.sessID, k.serverIP, []byte(iv))
+	return operationSetIV(ctx, k.request, []byte(iv))
 }
 
-// func (k *Ksema) Close() {
-// 	fmt.Println("Closing connection...")
-// }
+// Close end the session on every endpoint and release the resources
+// The background goroutines are stopped and the client drop its references to the credentials
+// Go strings can not be zeroed, so the credentials stay in memory until they are garbage collected
+//
+// After that, every operation return ErrClosed
+// It is safe to call it more than once and concurrently
+func (k *Ksema) Close(ctx context.Context) error {
+	k.closeOnce.Do(func() {
+		k.closed.Store(true)
+		close(k.stop)
+		k.wg.Wait()
+
+		k.healthMu.Lock()
+		close(k.healthCh)
+		k.healthMu.Unlock()
+
+		var errs []error
+		for _, ep := range k.endpoints {
+			if err := k.logout(ctx, ep); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		k.client.CloseIdleConnections()
+		if err := k.SaveUsage(); err != nil {
+			errs = append(errs, err)
+		}
+
+		// Drop the references only, the strings are immutable
+		k.mu.Lock()
+		k.creds = Credentials{}
+		k.mu.Unlock()
+		if k.dekCache != nil {
+			k.dekCache.purge()
+		}
+
+		k.closeErr = errors.Join(errs...)
+	})
+
+	return k.closeErr
+}
+
+// Send logout request to end the session of the endpoint
+func (k *Ksema) logout(ctx context.Context, ep *endpoint) error {
+	ep.mu.Lock()
+	sessID := ep.sessID
+	ep.sessID = ""
+	ep.mu.Unlock()
+
+	if sessID == "" {
+		return nil
+	}
+	return k.endSession(ctx, ep.address, sessID)
+}
+
+// Send logout request to end the session on the server
+// Servers without LOGOUT answer Invalid Packet, the session is left to expire
+func (k *Ksema) endSession(ctx context.Context, address, sessID string) error {
+	_, err := k.sendRequest(ctx, address, ServiceRequest{
+		SessionID: sessID,
+		Operation: FunctionLogout,
+	})
+	if errors.Is(err, ErrInvalidPacket) {
+		k.logger.DebugContext(ctx, "ksema logout not supported", "endpoint", address)
+		return nil
+	}
+	if err != nil && !isSessionInvalid(err) {
+		k.logger.WarnContext(ctx, "ksema logout failed", "endpoint", address, "error", err.Error())
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package ksema_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/suhailiealx/ksema-sdk-go"
+	"github.com/suhailiealx/ksema-sdk-go/ksematest"
+)
+
+func TestAuthPINErrors(t *testing.T) {
+	srv := newTestServer(t)
+
+	user := srv.NewUser(ksematest.UserBrigade)
+	wrong := *user
+	wrong.PIN = "00000000"
+
+	for range ksematest.MAX_PIN_ATTEMPTS {
+		_, err := srv.NewClient(&wrong)
+		if !errors.Is(err, ksema.ErrPINIncorrect) {
+			t.Fatalf("wrong PIN: got %v, want ErrPINIncorrect", err)
+		}
+	}
+
+	_, err := srv.NewClient(user)
+	if !errors.Is(err, ksema.ErrPINLocked) {
+		t.Fatalf("locked PIN: got %v, want ErrPINLocked", err)
+	}
+	var kErr *ksema.Error
+	if !errors.As(err, &kErr) || kErr.RetCode != ksema.PINLOCKED {
+		t.Fatalf("locked PIN: got %#v, want *Error with PINLOCKED", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	srv := newTestServer(t)
+	k := newTestClient(t, srv, ksematest.UserBrigade)
+	genKey(t, k, "AES01", "")
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = k.Close(ctx)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("close: %v", err)
+		}
+	}
+
+	if n := srv.Sessions(); n != 0 {
+		t.Fatalf("got %d sessions after close, want 0", n)
+	}
+	if _, err := k.Encrypt([]byte("data"), "AES01"); !errors.Is(err, ksema.ErrClosed) {
+		t.Fatalf("encrypt after close: got %v, want ErrClosed", err)
+	}
+	if err := k.Close(ctx); err != nil {
+		t.Fatalf("second close: %v", err)
+	}
+}
+
+func TestCloseLogoutNotSupported(t *testing.T) {
+	srv := newTestServer(t)
+	k := newTestClient(t, srv, ksematest.UserBrigade)
+
+	// Answer of a server without LOGOUT
+	srv.InjectRetCode(ksema.FunctionLogout, ksema.INVALIDPACKET, 1)
+	if err := k.Close(context.Background()); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+}
@@ -0,0 +1,349 @@
+package ksematest
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+
+	ksema "github.com/suhailiealx/ksema-sdk-go"
+)
+
+// Key type of the fake server
+const (
+	KeySymmetric = "SYM"
+	KeyPublic    = "PUB"
+	KeyPrivate   = "PRIV"
+)
+
+// Key stored in the fake server
+//
+// Symmetric key is AES-256 in CBC mode, keypair is ECDSA P-256
+// SIGN use the data as the digest to be signed
+type key struct {
+	Label string `json:"label"`
+	Type  string `json:"type"`
+	// Label of the other half of the keypair
+	Pair string `json:"pair,omitempty"`
+	// AES key or DER encoded public/private key
+	Material []byte `json:"material"`
+}
+
+type keyStore struct {
+	mu     sync.Mutex
+	keys   map[string]*key
+	object bool
+}
+
+func newKeyStore() *keyStore {
+	return &keyStore{keys: make(map[string]*key)}
+}
+
+// Provision the key of user object
+func (ks *keyStore) provisionObject(userType int) {
+	ks.object = true
+	ks.keys[""] = &key{Type: KeySymmetric, Material: randomBytes(32)}
+	if userType == UserContra {
+		pub, priv := newKeyPair()
+		ks.keys["pub"] = &key{Label: "pub", Type: KeyPublic, Pair: "priv", Material: pub}
+		ks.keys["priv"] = &key{Label: "priv", Type: KeyPrivate, Pair: "pub", Material: priv}
+	}
+}
+
+// Find the key of the label with one of the given types
+// User object does not need label since it only has its own key
+func (ks *keyStore) find(label string, types ...string) (*key, int) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	for _, k := range ks.keys {
+		if !slices.Contains(types, k.Type) {
+			continue
+		}
+		if k.Label == label || (label == "" && ks.object) {
+			return k, ksema.SUCCESS
+		}
+	}
+	return nil, ksema.NOLABELFOUND
+}
+
+// Find the other half of the keypair
+func (ks *keyStore) pairOf(k *key) *key {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return ks.keys[k.Pair]
+}
+
+func (ks *keyStore) encrypt(label string, iv, data []byte) ([]byte, int) {
+	k, retCode := ks.find(label, KeySymmetric)
+	if retCode != ksema.SUCCESS {
+		return nil, retCode
+	}
+
+	block, _ := aes.NewCipher(k.Material)
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	plain := append(bytes.Clone(data), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	out := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, plain)
+
+	return out, ksema.SUCCESS
+}
+
+func (ks *keyStore) decrypt(label string, iv, data []byte) ([]byte, int) {
+	k, retCode := ks.find(label, KeySymmetric)
+	if retCode != ksema.SUCCESS {
+		return nil, retCode
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, ksema.INVALIDENCRYPTED
+	}
+
+	block, _ := aes.NewCipher(k.Material)
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+
+	padding := int(out[len(out)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(out[len(out)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, ksema.INVALIDENCRYPTED
+	}
+
+	return out[:len(out)-padding], ksema.SUCCESS
+}
+
+func (ks *keyStore) sign(label string, data []byte) ([]byte, int) {
+	k, retCode := ks.find(label, KeyPrivate)
+	if retCode != ksema.SUCCESS {
+		return nil, retCode
+	}
+
+	priv, err := x509.ParseECPrivateKey(k.Material)
+	if err != nil {
+		return nil, ksema.FAILED
+	}
+	signature, err := ecdsa.SignASN1(rand.Reader, priv, data)
+	if err != nil {
+		return nil, ksema.FAILED
+	}
+
+	return signature, ksema.SUCCESS
+}
+
+// Verify the data, the label can be the public or private label of the keypair
+// The data contains the length of data, data, length of signature and signature
+func (ks *keyStore) verify(label string, data []byte) ([]byte, int) {
+	k, retCode := ks.find(label, KeyPublic, KeyPrivate)
+	if retCode != ksema.SUCCESS {
+		return nil, retCode
+	}
+	if k.Type == KeyPrivate {
+		if k = ks.pairOf(k); k == nil {
+			return nil, ksema.NOLABELFOUND
+		}
+	}
+
+	msg, signature, ok := parseVerifyData(data)
+	if !ok {
+		return nil, ksema.INVALIDPACKET
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(k.Material)
+	if err != nil {
+		return nil, ksema.FAILED
+	}
+	if !ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), msg, signature) {
+		return nil, ksema.FAILED
+	}
+
+	return nil, ksema.SUCCESS
+}
+
+// Return the DER encoded public key, the label can be the public or private label of the keypair
+func (ks *keyStore) publicKey(label string) ([]byte, int) {
+	k, retCode := ks.find(label, KeyPublic, KeyPrivate)
+	if retCode != ksema.SUCCESS {
+		return nil, retCode
+	}
+	if k.Type == KeyPrivate {
+		if k = ks.pairOf(k); k == nil {
+			return nil, ksema.NOLABELFOUND
+		}
+	}
+
+	return bytes.Clone(k.Material), ksema.SUCCESS
+}
+
+func parseVerifyData(data []byte) ([]byte, []byte, bool) {
+	if len(data) < 2 {
+		return nil, nil, false
+	}
+	msgLen := int(binary.BigEndian.Uint16(data))
+	if len(data) < 4+msgLen {
+		return nil, nil, false
+	}
+	msg := data[2 : 2+msgLen]
+	sigLen := int(binary.BigEndian.Uint16(data[2+msgLen:]))
+	if len(data) != 4+msgLen+sigLen {
+		return nil, nil, false
+	}
+	return msg, data[4+msgLen:], true
+}
+
+func (ks *keyStore) delete(label string) ([]byte, int) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if _, exists := ks.keys[label]; !exists || label == "" {
+		return nil, ksema.NOLABELFOUND
+	}
+	delete(ks.keys, label)
+
+	return nil, ksema.SUCCESS
+}
+
+func (ks *keyStore) genKeySym(label string) ([]byte, int) {
+	if label == "" {
+		return nil, ksema.INVALIDPACKET
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if _, exists := ks.keys[label]; exists {
+		return nil, ksema.KEYEXISTED
+	}
+	ks.keys[label] = &key{Label: label, Type: KeySymmetric, Material: randomBytes(32)}
+
+	return nil, ksema.SUCCESS
+}
+
+// Generate keypair, the label is "pubLabel;privLabel"
+func (ks *keyStore) genKeyAsym(label string) ([]byte, int) {
+	pubLabel, privLabel, found := strings.Cut(label, ";")
+	if !found || pubLabel == "" || privLabel == "" || pubLabel == privLabel {
+		return nil, ksema.INVALIDPACKET
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if ks.keys[pubLabel] != nil || ks.keys[privLabel] != nil {
+		return nil, ksema.KEYEXISTED
+	}
+	pub, priv := newKeyPair()
+	ks.keys[pubLabel] = &key{Label: pubLabel, Type: KeyPublic, Pair: privLabel, Material: pub}
+	ks.keys[privLabel] = &key{Label: privLabel, Type: KeyPrivate, Pair: pubLabel, Material: priv}
+
+	return nil, ksema.SUCCESS
+}
+
+// Export the key of the label
+//
+// The message contains the length of header and header, followed by the length and exported key
+// User object (Contra) has a second exported key for the private key of its keypair
+func (ks *keyStore) backup(masterKey []byte, userType int, label string) ([]byte, int) {
+	var exported []*key
+
+	if userType == ksema.USER_OBJECT {
+		pub, retCode := ks.find("", KeyPublic)
+		if retCode != ksema.SUCCESS {
+			return nil, retCode
+		}
+		exported = []*key{pub, ks.pairOf(pub)}
+	} else {
+		k, retCode := ks.find(label, KeySymmetric, KeyPublic, KeyPrivate)
+		if retCode != ksema.SUCCESS {
+			return nil, retCode
+		}
+		exported = []*key{k}
+	}
+
+	header := "KSEMA;" + exported[0].Type + ";" + exported[0].Label
+	out := appendLengthPrefixed(nil, []byte(header))
+	for _, k := range exported {
+		blob, err := wrapKey(masterKey, k)
+		if err != nil {
+			return nil, ksema.FAILED
+		}
+		out = appendLengthPrefixed(out, blob)
+	}
+
+	return out, ksema.SUCCESS
+}
+
+// Import a key exported by backup
+func (ks *keyStore) restore(masterKey []byte, data []byte) ([]byte, int) {
+	k, err := unwrapKey(masterKey, bytes.TrimSpace(data))
+	if err != nil {
+		return nil, ksema.INVALIDPACKET
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if _, exists := ks.keys[k.Label]; exists {
+		return nil, ksema.KEYEXISTED
+	}
+	ks.keys[k.Label] = k
+
+	return nil, ksema.SUCCESS
+}
+
+// Encrypt the key with the master key and encode it in base64
+func wrapKey(masterKey []byte, k *key) ([]byte, error) {
+	plain, err := json.Marshal(k)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := aes.NewCipher(masterKey)
+	gcm, _ := cipher.NewGCM(block)
+	nonce := randomBytes(gcm.NonceSize())
+	sealed := gcm.Seal(nonce, nonce, plain, nil)
+
+	return []byte(base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+func unwrapKey(masterKey []byte, blob []byte) (*key, error) {
+	sealed, err := base64.StdEncoding.DecodeString(string(blob))
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := aes.NewCipher(masterKey)
+	gcm, _ := cipher.NewGCM(block)
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ksema.ErrInvalidPacket
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var k key
+	if err := json.Unmarshal(plain, &k); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func appendLengthPrefixed(out, data []byte) []byte {
+	out = binary.BigEndian.AppendUint16(out, uint16(len(data)))
+	return append(out, data...)
+}
+
+// Generate ECDSA P-256 keypair, return the DER of public and private key
+func newKeyPair() ([]byte, []byte) {
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pub, _ := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	der, _ := x509.MarshalECPrivateKey(priv)
+	return pub, der
+}
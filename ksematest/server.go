@@ -0,0 +1,408 @@
+// Package ksematest provides an in-process fake Ksema server for hermetic tests
+//
+// The server speaks the same /api/hsm/auth and /api/hsm/request protocol as the appliance,
+// implements every operation with software crypto and enforce the user privileges
+package ksematest
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+
+	ksema "github.com/suhailiealx/ksema-sdk-go"
+)
+
+// User types of the fake server
+// Fighter and Contra are user object, the other are user slot
+const (
+	UserFighter   = 1
+	UserContra    = ksema.USER_OBJECT
+	UserPlatoon   = 3
+	UserBattalion = 4
+	UserBrigade   = 5
+)
+
+// Number of incorrect PIN before the user is locked
+const MAX_PIN_ATTEMPTS = 3
+
+// IV used by a new session
+var DEFAULT_IV = []byte("0000000000000000")
+
+// Operations allowed for each user object, user slot can use all operations
+var objectPrivileges = map[int][]string{
+	UserFighter: {
+		ksema.FunctionPing, ksema.FunctionSetIV,
+		ksema.FunctionEncrypt, ksema.FunctionDecrypt, ksema.FunctionRNG, ksema.FunctionBackup,
+	},
+	UserContra: {
+		ksema.FunctionPing, ksema.FunctionSetIV,
+		ksema.FunctionEncrypt, ksema.FunctionDecrypt, ksema.FunctionSign, ksema.FunctionVerify,
+		ksema.FunctionRNG, ksema.FunctionBackup,
+	},
+}
+
+// User registered in the fake server
+type User struct {
+	PassKey string
+	APIKey  string
+	PIN     string
+	Type    int
+
+	pinAttempts int
+	keys        *keyStore
+}
+
+type session struct {
+	user *User
+	iv   []byte
+}
+
+type fault struct {
+	op      string
+	retCode int
+	times   int
+}
+
+// Server is a fake Ksema server
+type Server struct {
+	*httptest.Server
+
+	// Key used to wrap the exported key of backup
+	masterKey []byte
+
+	mu       sync.Mutex
+	users    map[string]*User
+	sessions map[string]*session
+	faults   []*fault
+}
+
+// NewServer start a fake Ksema server with TLS
+// The server must be closed after use
+func NewServer() *Server {
+	s := &Server{
+		masterKey: randomBytes(32),
+		users:     make(map[string]*User),
+		sessions:  make(map[string]*session),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/hsm/auth", s.handleAuth)
+	mux.HandleFunc("POST /api/hsm/request", s.handleRequest)
+	s.Server = httptest.NewTLSServer(mux)
+
+	return s
+}
+
+// Addr return the address to be used as server IP of the client
+func (s *Server) Addr() string {
+	return s.Listener.Addr().String()
+}
+
+// CertPool return a pool which trust the certificate of the server
+func (s *Server) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.Certificate())
+	return pool
+}
+
+// AddUser register a user
+//
+// User object is provisioned with its single key (and keypair for Contra) which does not need label
+func (s *Server) AddUser(user User) *User {
+	u := &user
+	u.keys = newKeyStore()
+	if u.Type <= ksema.USER_OBJECT {
+		u.keys.provisionObject(u.Type)
+	}
+
+	s.mu.Lock()
+	s.users[u.PassKey+":"+u.APIKey] = u
+	s.mu.Unlock()
+
+	return u
+}
+
+// NewUser register a user of the given type with random credentials
+func (s *Server) NewUser(userType int) *User {
+	user := User{
+		PassKey: hex.EncodeToString(randomBytes(28)),
+		APIKey:  hex.EncodeToString(randomBytes(32)),
+		Type:    userType,
+	}
+	if userType > ksema.USER_OBJECT {
+		user.PIN = "12345678"
+	}
+	return s.AddUser(user)
+}
+
+// Credentials return the credentials of the user
+func (u *User) Credentials() ksema.Credentials {
+	return ksema.Credentials{
+		PassKey: u.PassKey,
+		APIKey:  u.APIKey,
+		PIN:     u.PIN,
+	}
+}
+
+// NewClient return a Ksema object connected to the server with the user credentials
+// The given options are applied after the TLS configuration of the server
+func (s *Server) NewClient(user *User, opts ...ksema.Option) (*ksema.Ksema, error) {
+	opts = append([]ksema.Option{ksema.WithRootCAs(s.CertPool())}, opts...)
+	return ksema.NewWithOptions(s.Addr(), user.Credentials(), opts...)
+}
+
+// NewKsema register a new user of the given type and return a Ksema object connected with it
+func (s *Server) NewKsema(userType int, opts ...ksema.Option) (*ksema.Ksema, error) {
+	return s.NewClient(s.NewUser(userType), opts...)
+}
+
+// InjectRetCode make the next times requests of the operation fail with the return code
+// Empty operation match every operation
+func (s *Server) InjectRetCode(op string, retCode int, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &fault{op: op, retCode: retCode, times: times})
+}
+
+// Sessions return the number of active sessions
+func (s *Server) Sessions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+// ExpireSessions invalidate every session, the next request return SESSIONINVALID
+func (s *Server) ExpireSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.sessions)
+}
+
+// Return the injected return code of the operation, if any
+func (s *Server) takeFault(op string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, f := range s.faults {
+		if f.op != "" && f.op != op {
+			continue
+		}
+		f.times--
+		if f.times <= 0 {
+			s.faults = slices.Delete(s.faults, i, i+1)
+		}
+		return f.retCode, true
+	}
+	return 0, false
+}
+
+func (s *Server) handleAuth(w http.ResponseWriter, r *http.Request) {
+	var req ksema.AuthRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ksema.AuthResponse{ErrorMsg: "invalid request"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[req.Passkey+":"+req.APIKey]
+	if !exists {
+		writeJSON(w, http.StatusUnauthorized, ksema.AuthResponse{ErrorMsg: "invalid credentials"})
+		return
+	}
+	if user.Type > ksema.USER_OBJECT {
+		if user.pinAttempts >= MAX_PIN_ATTEMPTS {
+			writeJSON(w, http.StatusUnauthorized, authFailure(ksema.PINLOCKED))
+			return
+		}
+		if req.PIN != user.PIN {
+			user.pinAttempts++
+			writeJSON(w, http.StatusUnauthorized, authFailure(ksema.PININCORRECT))
+			return
+		}
+		user.pinAttempts = 0
+	}
+
+	sessID := hex.EncodeToString(randomBytes(16))
+	s.sessions[sessID] = &session{user: user, iv: DEFAULT_IV}
+
+	writeJSON(w, http.StatusOK, ksema.AuthResponse{
+		Success: true,
+		Data: ksema.AuthData{
+			SessionID: sessID,
+			UserType:  user.Type,
+		},
+	})
+}
+
+func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
+	var req ksema.ServiceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, failure(ksema.INVALIDPACKET))
+		return
+	}
+
+	if retCode, injected := s.takeFault(req.Operation); injected {
+		writeJSON(w, http.StatusOK, failure(retCode))
+		return
+	}
+
+	s.mu.Lock()
+	sess, exists := s.sessions[req.SessionID]
+	s.mu.Unlock()
+	if !exists {
+		writeJSON(w, http.StatusOK, failure(ksema.SESSIONINVALID))
+		return
+	}
+
+	if req.Operation == ksema.FunctionLogout {
+		s.mu.Lock()
+		delete(s.sessions, req.SessionID)
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, ksema.ServiceResponse{
+			Success: true,
+			Data:    ksema.Data{RetCode: ksema.SUCCESS},
+		})
+		return
+	}
+
+	if !allowed(sess.user.Type, req.Operation) {
+		writeJSON(w, http.StatusOK, failure(ksema.UNAUTHORIZEDFUNC))
+		return
+	}
+
+	msg, retCode := s.execute(sess, &req)
+	if retCode != ksema.SUCCESS {
+		writeJSON(w, http.StatusOK, failure(retCode))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ksema.ServiceResponse{
+		Success: true,
+		Data: ksema.Data{
+			Message: base64.StdEncoding.EncodeToString(msg),
+			RetCode: ksema.SUCCESS,
+		},
+	})
+}
+
+// Check if the user type can use the operation
+func allowed(userType int, op string) bool {
+	if userType > ksema.USER_OBJECT {
+		return true
+	}
+	return slices.Contains(objectPrivileges[userType], op)
+}
+
+// Execute the operation
+// Return the message and return code
+func (s *Server) execute(sess *session, req *ksema.ServiceRequest) ([]byte, int) {
+	keys := sess.user.keys
+
+	switch req.Operation {
+	case ksema.FunctionPing:
+		return nil, ksema.SUCCESS
+	case ksema.FunctionSetIV:
+		if len(req.Data) != 16 {
+			return nil, ksema.INVALIDPACKET
+		}
+		s.mu.Lock()
+		sess.iv = slices.Clone(req.Data)
+		s.mu.Unlock()
+		return nil, ksema.SUCCESS
+	case ksema.FunctionEncrypt:
+		return keys.encrypt(req.Label, s.sessionIV(sess), req.Data)
+	case ksema.FunctionDecrypt:
+		return keys.decrypt(req.Label, s.sessionIV(sess), req.Data)
+	case ksema.FunctionSign:
+		return keys.sign(req.Label, req.Data)
+	case ksema.FunctionVerify:
+		return keys.verify(req.Label, req.Data)
+	case ksema.FunctionRNG:
+		return random(req.Data)
+	case ksema.FunctionBackup:
+		return keys.backup(s.masterKey, sess.user.Type, req.Label)
+	case ksema.FunctionRestore:
+		return keys.restore(s.masterKey, req.Data)
+	case ksema.FunctionDelete:
+		return keys.delete(req.Label)
+	case ksema.FunctionGenKeySym:
+		return keys.genKeySym(req.Label)
+	case ksema.FunctionGenKeyAsym:
+		return keys.genKeyAsym(req.Label)
+	case ksema.FunctionGetPubKey:
+		return keys.publicKey(req.Label)
+	}
+
+	return nil, ksema.INVALIDPACKET
+}
+
+func (s *Server) sessionIV(sess *session) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sess.iv
+}
+
+// Generate random data, the data contains the length or empty for the default length
+func random(data []byte) ([]byte, int) {
+	length := ksema.DEFAULT_RANDOM_LEN
+	switch len(data) {
+	case 0:
+	case 2:
+		length = int(data[0])<<8 | int(data[1])
+	default:
+		return nil, ksema.INVALIDPACKET
+	}
+	return randomBytes(length), ksema.SUCCESS
+}
+
+func failure(retCode int) ksema.ServiceResponse {
+	msg, exists := ksemaRetCodeMessage[retCode]
+	if !exists {
+		msg = fmt.Sprintf("return code %d", retCode)
+	}
+	return ksema.ServiceResponse{
+		Data:     ksema.Data{RetCode: retCode},
+		ErrorMsg: msg,
+	}
+}
+
+func authFailure(retCode int) ksema.AuthResponse {
+	return ksema.AuthResponse{
+		Data:     ksema.AuthData{RetCode: retCode},
+		ErrorMsg: ksemaRetCodeMessage[retCode],
+	}
+}
+
+var ksemaRetCodeMessage = map[int]string{
+	ksema.FAILED:           "failed",
+	ksema.NOLABELFOUND:     "no label found",
+	ksema.MAXUSAGE:         "max usage reached",
+	ksema.UNAUTHORIZEDFUNC: "unauthorized function",
+	ksema.INVALIDPACKET:    "invalid packet",
+	ksema.KEYEXISTED:       "key already existed",
+	ksema.PININCORRECT:     "pin incorrect",
+	ksema.PINLOCKED:        "pin locked",
+	ksema.SESSIONINVALID:   "session invalid",
+	ksema.INVALIDENCRYPTED: "invalid encrypted data",
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
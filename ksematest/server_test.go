@@ -0,0 +1,109 @@
+package ksematest_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/suhailiealx/ksema-sdk-go"
+	"github.com/suhailiealx/ksema-sdk-go/ksematest"
+)
+
+func TestPrivileges(t *testing.T) {
+	srv := newTestServer(t)
+
+	fighter := newTestClient(t, srv, ksematest.UserFighter)
+	if _, err := fighter.Encrypt([]byte("data"), ""); err != nil {
+		t.Fatalf("fighter encrypt: %v", err)
+	}
+	if _, err := fighter.Sign([]byte("data"), ""); !errors.Is(err, ksema.ErrUnauthorized) {
+		t.Fatalf("fighter sign: got %v, want ErrUnauthorized", err)
+	}
+	if err := fighter.GenKey("label", ""); !errors.Is(err, ksema.ErrUnauthorized) {
+		t.Fatalf("fighter genkey: got %v, want ErrUnauthorized", err)
+	}
+
+	contra := newTestClient(t, srv, ksematest.UserContra)
+	signature, err := contra.Sign([]byte("data"), "")
+	if err != nil {
+		t.Fatalf("contra sign: %v", err)
+	}
+	if err := contra.Verify([]byte("data"), signature, ""); err != nil {
+		t.Fatalf("contra verify: %v", err)
+	}
+	if err := contra.Delete("pub"); !errors.Is(err, ksema.ErrUnauthorized) {
+		t.Fatalf("contra delete: got %v, want ErrUnauthorized", err)
+	}
+
+	brigade := newTestClient(t, srv, ksematest.UserBrigade)
+	if err := brigade.GenKey("pub", "priv"); err != nil {
+		t.Fatalf("brigade genkey: %v", err)
+	}
+	if err := brigade.GenKey("pub", "priv"); !errors.Is(err, ksema.ErrKeyExisted) {
+		t.Fatalf("brigade genkey again: got %v, want ErrKeyExisted", err)
+	}
+	if _, err := brigade.Encrypt([]byte("data"), "missing"); !errors.Is(err, ksema.ErrNoLabelFound) {
+		t.Fatalf("brigade encrypt: got %v, want ErrNoLabelFound", err)
+	}
+}
+
+func TestInjectRetCode(t *testing.T) {
+	srv := newTestServer(t)
+
+	k := newTestClient(t, srv, ksematest.UserFighter)
+	srv.InjectRetCode(ksema.FunctionEncrypt, ksema.MAXUSAGE, 2)
+
+	for range 2 {
+		if _, err := k.Encrypt([]byte("data"), ""); !errors.Is(err, ksema.ErrMaxUsage) {
+			t.Fatalf("encrypt: got %v, want ErrMaxUsage", err)
+		}
+	}
+	if err := k.Ping(); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+	if _, err := k.Encrypt([]byte("data"), ""); err != nil {
+		t.Fatalf("encrypt after the injected faults: %v", err)
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	srv := newTestServer(t)
+	ctx := context.Background()
+
+	k := newTestClient(t, srv, ksematest.UserBrigade)
+	if err := k.GenKey("AES01", ""); err != nil {
+		t.Fatal(err)
+	}
+	cipherText, err := k.Encrypt([]byte("plain text"), "AES01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backup, err := k.BackupKey(ctx, "AES01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup.KeyType != ksematest.KeySymmetric || backup.Label != "AES01" || len(backup.Blobs) != 1 {
+		t.Fatalf("unexpected backup %q with %d blobs", backup.Header, len(backup.Blobs))
+	}
+
+	var buf bytes.Buffer
+	if _, err := backup.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Delete("AES01"); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.RestoreFrom(ctx, &buf); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+
+	plainText, err := k.Decrypt(cipherText, "AES01")
+	if err != nil {
+		t.Fatalf("decrypt with the restored key: %v", err)
+	}
+	if string(plainText) != "plain text" {
+		t.Fatalf("got %q, want %q", plainText, "plain text")
+	}
+}
@@ -0,0 +1,104 @@
+package ksema
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// Value logged in place of secrets
+const REDACTED = "[REDACTED]"
+
+// WithLogger set the logger of diagnostics
+// Default logger discard everything
+//
+// Session ID, PIN, API key, passkey and payload are never logged
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *config) {
+		if logger == nil {
+			logger = slog.New(slog.DiscardHandler)
+		}
+		c.logger = logger
+	}
+}
+
+// Log the result of a request
+func (k *Ksema) logRequest(ctx context.Context, payload ServiceRequest, info *CallInfo, duration time.Duration, err error) {
+	level := slog.LevelDebug
+	if err != nil {
+		level = slog.LevelWarn
+	}
+	if !k.logger.Enabled(ctx, level) {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("op", payload.Operation),
+		slog.String("label", payload.Label),
+		slog.Duration("duration", duration),
+		slog.Int("retCode", retCodeOf(err)),
+		slog.String("endpoint", info.Endpoint),
+		slog.Int("attempts", info.Attempts),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+
+	k.logger.LogAttrs(ctx, level, "ksema request", attrs...)
+}
+
+// Return the return code of the error
+// SUCCESS if there is no error, FAILED if the server does not answer
+func retCodeOf(err error) int {
+	if err == nil {
+		return SUCCESS
+	}
+	var kErr *Error
+	if errors.As(err, &kErr) {
+		return kErr.RetCode
+	}
+	return FAILED
+}
+
+// LogValue redact the secrets of the credentials
+func (c Credentials) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("passKey", REDACTED),
+		slog.String("apiKey", REDACTED),
+		slog.String("pin", REDACTED),
+	)
+}
+
+// LogValue redact the secrets of the request
+func (r AuthRequest) LogValue() slog.Value {
+	return Credentials{}.LogValue()
+}
+
+// LogValue redact the session ID
+func (d AuthData) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("sessionId", REDACTED),
+		slog.Int("userType", d.UserType),
+	)
+}
+
+// LogValue redact the session ID and payload of the request
+func (r ServiceRequest) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("sessionId", REDACTED),
+		slog.String("operation", r.Operation),
+		slog.String("label", r.Label),
+		slog.Int("dataLen", len(r.Data)),
+	)
+}
+
+// LogValue redact the payload of the response
+func (r ServiceResponse) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Bool("success", r.Success),
+		slog.Int("retCode", r.Data.RetCode),
+		slog.Int("messageLen", len(r.Data.Message)),
+		slog.String("error", r.ErrorMsg),
+	)
+}
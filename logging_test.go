@@ -0,0 +1,115 @@
+package ksema_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/suhailiealx/ksema-sdk-go"
+	"github.com/suhailiealx/ksema-sdk-go/ksematest"
+)
+
+const (
+	secretPassKey   = "passkey-0123456789"
+	secretAPIKey    = "apikey-0123456789"
+	secretPIN       = "98765432"
+	secretSessionID = "session-0123456789"
+	secretPayload   = "payload-0123456789"
+)
+
+// Fail if one of the secrets is in the logs
+func checkRedacted(t *testing.T, logs string, secrets ...string) {
+	t.Helper()
+	for _, secret := range secrets {
+		if strings.Contains(logs, secret) {
+			t.Fatalf("secret %q logged in %s", secret, logs)
+		}
+	}
+}
+
+func TestLogValueRedacted(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	logger.Info("values",
+		"credentials", ksema.Credentials{PassKey: secretPassKey, APIKey: secretAPIKey, PIN: secretPIN},
+		"authRequest", ksema.AuthRequest{Passkey: secretPassKey, APIKey: secretAPIKey, PIN: secretPIN},
+		"authData", ksema.AuthData{SessionID: secretSessionID, UserType: ksematest.UserBrigade},
+		"request", ksema.ServiceRequest{SessionID: secretSessionID, Operation: ksema.FunctionEncrypt, Label: "AES01", Data: []byte(secretPayload)},
+	)
+
+	logs := buf.String()
+	checkRedacted(t, logs, secretPassKey, secretAPIKey, secretPIN, secretSessionID, secretPayload,
+		base64.StdEncoding.EncodeToString([]byte(secretPayload)))
+
+	var entry struct {
+		Request struct {
+			SessionID string `json:"sessionId"`
+			Operation string `json:"operation"`
+			Label     string `json:"label"`
+			DataLen   int    `json:"dataLen"`
+		} `json:"request"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if r := entry.Request; r.SessionID != ksema.REDACTED || r.Operation != ksema.FunctionEncrypt || r.Label != "AES01" || r.DataLen != len(secretPayload) {
+		t.Fatalf("unexpected request log %+v", r)
+	}
+}
+
+func TestLogRequest(t *testing.T) {
+	srv := newTestServer(t)
+	user := srv.NewUser(ksematest.UserBrigade)
+
+	var buf syncBuffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	k, err := srv.NewClient(user, ksema.WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	genKey(t, k, "AES01", "")
+
+	cipherText, err := k.Encrypt([]byte(secretPayload), "AES01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Failed request is logged with its error
+	if _, err := k.Encrypt([]byte(secretPayload), "MISSING"); err == nil {
+		t.Fatal("encrypt with a missing label succeeded")
+	}
+	if err := k.Close(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	logs := buf.String()
+	checkRedacted(t, logs, user.PassKey, user.APIKey, user.PIN, secretPayload,
+		base64.StdEncoding.EncodeToString([]byte(secretPayload)),
+		base64.StdEncoding.EncodeToString(cipherText))
+
+	var requests int
+	for _, line := range strings.Split(strings.TrimSpace(logs), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry["msg"] != "ksema request" || entry["op"] != ksema.FunctionEncrypt {
+			continue
+		}
+		requests++
+		for _, key := range []string{"label", "duration", "retCode", "endpoint"} {
+			if _, ok := entry[key]; !ok {
+				t.Fatalf("request log without %s: %s", key, line)
+			}
+		}
+		if entry["endpoint"] != srv.Addr() {
+			t.Fatalf("logged endpoint %v, want %s", entry["endpoint"], srv.Addr())
+		}
+	}
+	if requests != 2 {
+		t.Fatalf("got %d encrypt logs, want 2", requests)
+	}
+}
@@ -14,6 +14,8 @@ type AuthRequest struct {
 type AuthData struct {
 	SessionID string `json:"sessionId"`
 	UserType  int    `json:"userType"`
+	// Return code of a failed authentication, e.g. PINLOCKED
+	RetCode int `json:"retCode,omitempty"`
 }
 
 type AuthResponse struct {
@@ -65,6 +67,8 @@ const (
 	FunctionGenKeySym  = "GENKEYSYM"
 	FunctionGenKeyAsym = "GENKEYASYM"
 	FunctionSetIV      = "SETIV"
+	FunctionLogout     = "LOGOUT"    // Assumed, not documented by the server
+	FunctionGetPubKey  = "GETPUBKEY" // Assumed, not documented by the server
 )
 
 var mapRetCodeToString map[int]string = map[int]string{
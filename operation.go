@@ -2,229 +2,153 @@ package ksema
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
-	"os"
 )
 
-func operationPing(client *http.Client, sessionId string, serverIP string) error {
-	payload := ServiceRequest{
-		SessionID: sessionId,
-		Operation: "PING",
-	}
+// Function used by the operations to send a request
+// The session ID of the payload is filled by the implementation
+type requestFunc func(ctx context.Context, payload ServiceRequest) (*ServiceResponse, error)
+
+// Send a request to the server and check the returned code
+// Return the response if success
+func (k *Ksema) sendRequest(ctx context.Context, serverIP string, payload ServiceRequest) (*ServiceResponse, error) {
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
-		fmt.Printf("Error marshaling JSON: %v\n", err)
-		return err
+		return nil, fmt.Errorf("marshaling request: %w", err)
 	}
 
-	resp, err := client.Post(fmt.Sprintf("https://%s/api/hsm/request", serverIP), "application/json", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("https://%s/api/hsm/request", serverIP), bytes.NewBuffer(jsonData))
 	if err != nil {
-		fmt.Printf("Error making GET request: %v\n", err)
-		return err
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if k.instrumentation != nil {
+		k.instrumentation.Inject(ctx, req.Header)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("making POST request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Read and print response
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Printf("Error reading response body: %v\n", err)
-		return err
+		return nil, fmt.Errorf("reading response body: %w", err)
 	}
 
 	var res ServiceResponse
 	err = json.Unmarshal(body, &res)
 	if err != nil {
-		fmt.Printf("Error unmarshaling response: %v\n", err)
-		return err
-	}
-
-	if !res.Success {
-		if res.ErrorMsg != "" {
-			return errors.New(res.ErrorMsg)
+		if resp.StatusCode/100 != 2 {
+			return nil, &Error{
+				Op:         payload.Operation,
+				Label:      payload.Label,
+				RetCode:    FAILED,
+				ErrorMsg:   http.StatusText(resp.StatusCode),
+				HTTPStatus: resp.StatusCode,
+			}
 		}
-		return errors.New("return auth request is false")
+		return nil, fmt.Errorf("unmarshaling response: %w", err)
 	}
-	if res.Data.RetCode != SUCCESS {
-		return errors.New(getReturnCodeMessage(res.Data.RetCode))
+
+	if err := checkResponse(&payload, &res, resp.StatusCode); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return &res, nil
 }
 
-func operationEncrypt(client *http.Client, sessionId string, serverIP string, plainText []byte, keyLabel string) ([]byte, error) {
-	var err error
-
-	payload := ServiceRequest{
-		SessionID: sessionId,
-		Operation: FunctionEncrypt,
-		Label:     keyLabel,
-		Data:      plainText,
-	}
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		fmt.Printf("Error marshaling JSON: %v\n", err)
-		return nil, err
+// Check the returned code of the response
+func checkResponse(payload *ServiceRequest, res *ServiceResponse, status int) error {
+	if res == nil {
+		return &Error{
+			Op:         payload.Operation,
+			Label:      payload.Label,
+			RetCode:    FAILED,
+			ErrorMsg:   "no response",
+			HTTPStatus: status,
+		}
 	}
-
-	resp, err := client.Post(fmt.Sprintf("https://%s/api/hsm/request", serverIP), "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		fmt.Printf("Error making GET request: %v\n", err)
-		return nil, err
+	if !res.Success || res.Data.RetCode != SUCCESS {
+		return &Error{
+			Op:         payload.Operation,
+			Label:      payload.Label,
+			RetCode:    res.Data.RetCode,
+			ErrorMsg:   res.ErrorMsg,
+			HTTPStatus: status,
+		}
 	}
-	defer resp.Body.Close()
+	return nil
+}
 
-	// Read and print response
-	body, err := ioutil.ReadAll(resp.Body)
+// Decode the base64 message of the response
+func decodeMessage(res *ServiceResponse) ([]byte, error) {
+	msg, err := base64.StdEncoding.DecodeString(res.Data.Message)
 	if err != nil {
-		fmt.Printf("Error reading response body: %v\n", err)
-		return nil, err
+		return nil, fmt.Errorf("decoding return message: %w", err)
 	}
+	return msg, nil
+}
 
-	var res ServiceResponse
-	err = json.Unmarshal(body, &res)
-	if err != nil {
-		fmt.Printf("Error unmarshaling response: %v\n", err)
-		return nil, err
+func operationPing(ctx context.Context, send requestFunc) error {
+	payload := ServiceRequest{
+		Operation: FunctionPing,
 	}
+	_, err := send(ctx, payload)
+	return err
+}
 
-	if !res.Success {
-		if res.ErrorMsg != "" {
-			return nil, errors.New(res.ErrorMsg)
-		}
-		return nil, errors.New("return auth request is false")
-	}
-	if res.Data.RetCode != SUCCESS {
-		return nil, errors.New(getReturnCodeMessage(res.Data.RetCode))
+func operationEncrypt(ctx context.Context, send requestFunc, plainText []byte, keyLabel string) ([]byte, error) {
+	payload := ServiceRequest{
+		Operation: FunctionEncrypt,
+		Label:     keyLabel,
+		Data:      plainText,
 	}
-
-	cipher, err := base64.StdEncoding.DecodeString(res.Data.Message)
+	res, err := send(ctx, payload)
 	if err != nil {
-		fmt.Printf("Error decoding return message: %v\n", err)
 		return nil, err
 	}
 
-	return cipher, nil
+	return decodeMessage(res)
 }
 
-func operationDecrypt(client *http.Client, sessionId string, serverIP string, cipherText []byte, keyLabel string) ([]byte, error) {
-	var err error
-
+func operationDecrypt(ctx context.Context, send requestFunc, cipherText []byte, keyLabel string) ([]byte, error) {
 	payload := ServiceRequest{
-		SessionID: sessionId,
 		Operation: FunctionDecrypt,
 		Label:     keyLabel,
 		Data:      cipherText,
 	}
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		fmt.Printf("Error marshaling JSON: %v\n", err)
-		return nil, err
-	}
-
-	resp, err := client.Post(fmt.Sprintf("https://%s/api/hsm/request", serverIP), "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		fmt.Printf("Error making GET request: %v\n", err)
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	// Read and print response
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("Error reading response body: %v\n", err)
-		return nil, err
-	}
-
-	var res ServiceResponse
-	err = json.Unmarshal(body, &res)
+	res, err := send(ctx, payload)
 	if err != nil {
-		fmt.Printf("Error unmarshaling response: %v\n", err)
 		return nil, err
 	}
 
-	if !res.Success {
-		if res.ErrorMsg != "" {
-			return nil, errors.New(res.ErrorMsg)
-		}
-		return nil, errors.New("return auth request is false")
-	}
-	if res.Data.RetCode != SUCCESS {
-		return nil, errors.New(getReturnCodeMessage(res.Data.RetCode))
-	}
-
-	plain, err := base64.StdEncoding.DecodeString(res.Data.Message)
-	if err != nil {
-		fmt.Printf("Error decoding return message: %v\n", err)
-		return nil, err
-	}
-
-	return plain, nil
+	return decodeMessage(res)
 }
 
-func operationSign(client *http.Client, sessionId string, serverIP string, data []byte, keyLabel string) ([]byte, error) {
-	var err error
-
+func operationSign(ctx context.Context, send requestFunc, data []byte, keyLabel string) ([]byte, error) {
 	payload := ServiceRequest{
-		SessionID: sessionId,
 		Operation: FunctionSign,
 		Label:     keyLabel,
 		Data:      data,
 	}
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		fmt.Printf("Error marshaling JSON: %v\n", err)
-		return nil, err
-	}
-
-	resp, err := client.Post(fmt.Sprintf("https://%s/api/hsm/request", serverIP), "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		fmt.Printf("Error making GET request: %v\n", err)
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	// Read and print response
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("Error reading response body: %v\n", err)
-		return nil, err
-	}
-
-	var res ServiceResponse
-	err = json.Unmarshal(body, &res)
-	if err != nil {
-		fmt.Printf("Error unmarshaling response: %v\n", err)
-		return nil, err
-	}
-
-	if !res.Success {
-		if res.ErrorMsg != "" {
-			return nil, errors.New(res.ErrorMsg)
-		}
-		return nil, errors.New("return auth request is false")
-	}
-	if res.Data.RetCode != SUCCESS {
-		return nil, errors.New(getReturnCodeMessage(res.Data.RetCode))
-	}
-
-	signature, err := base64.StdEncoding.DecodeString(res.Data.Message)
+	res, err := send(ctx, payload)
 	if err != nil {
-		fmt.Printf("Error decoding return message: %v\n", err)
 		return nil, err
 	}
 
-	return signature, nil
+	return decodeMessage(res)
 }
 
-func operationVerify(client *http.Client, sessionId string, serverIP string, data []byte, signature []byte, keyLabel string) error {
-	var err error
-
+func operationVerify(ctx context.Context, send requestFunc, data []byte, signature []byte, keyLabel string) error {
 	dataLen := len(data)
 	signatureLen := len(signature)
 
@@ -233,431 +157,154 @@ func operationVerify(client *http.Client, sessionId string, serverIP string, dat
 	dataPayload = append(dataPayload, signature...)
 
 	payload := ServiceRequest{
-		SessionID: sessionId,
 		Operation: FunctionVerify,
 		Label:     keyLabel,
 		Data:      dataPayload,
 	}
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		fmt.Printf("Error marshaling JSON: %v\n", err)
-		return err
-	}
-
-	resp, err := client.Post(fmt.Sprintf("https://%s/api/hsm/request", serverIP), "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		fmt.Printf("Error making GET request: %v\n", err)
-		return err
-	}
-	defer resp.Body.Close()
-
-	// Read and print response
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("Error reading response body: %v\n", err)
-		return err
-	}
-
-	var res ServiceResponse
-	err = json.Unmarshal(body, &res)
-	if err != nil {
-		fmt.Printf("Error unmarshaling response: %v\n", err)
-		return err
-	}
-
-	if !res.Success {
-		if res.ErrorMsg != "" {
-			return errors.New(res.ErrorMsg)
-		}
-		return errors.New("return auth request is false")
-	}
-	if res.Data.RetCode != SUCCESS {
-		return errors.New(getReturnCodeMessage(res.Data.RetCode))
-	}
-
-	return nil
+	_, err := send(ctx, payload)
+	return err
 }
 
-func operationRNG(client *http.Client, sessionId string, serverIP string, data []byte) ([]byte, error) {
-	var err error
-
+func operationRNG(ctx context.Context, send requestFunc, data []byte) ([]byte, error) {
 	payload := ServiceRequest{
-		SessionID: sessionId,
 		Operation: FunctionRNG,
 		Data:      data,
 	}
-	jsonData, err := json.Marshal(payload)
+	res, err := send(ctx, payload)
 	if err != nil {
-		fmt.Printf("Error marshaling JSON: %v\n", err)
 		return nil, err
 	}
 
-	resp, err := client.Post(fmt.Sprintf("https://%s/api/hsm/request", serverIP), "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		fmt.Printf("Error making GET request: %v\n", err)
-		return nil, err
-	}
-	defer resp.Body.Close()
+	return decodeMessage(res)
+}
 
-	// Read and print response
-	body, err := ioutil.ReadAll(resp.Body)
+func operationBackup(ctx context.Context, send requestFunc, userType int, keyLabel string) (*BackupBlob, error) {
+	payload := ServiceRequest{
+		Operation: FunctionBackup,
+		Label:     keyLabel,
+	}
+	res, err := send(ctx, payload)
 	if err != nil {
-		fmt.Printf("Error reading response body: %v\n", err)
 		return nil, err
 	}
 
-	var res ServiceResponse
-	err = json.Unmarshal(body, &res)
+	dataBackup, err := decodeMessage(res)
 	if err != nil {
-		fmt.Printf("Error unmarshaling response: %v\n", err)
 		return nil, err
 	}
 
-	if !res.Success {
-		if res.ErrorMsg != "" {
-			return nil, errors.New(res.ErrorMsg)
-		}
-		return nil, errors.New("return auth request is false")
-	}
-	if res.Data.RetCode != SUCCESS {
-		return nil, errors.New(getReturnCodeMessage(res.Data.RetCode))
-	}
-
-	random, err := base64.StdEncoding.DecodeString(res.Data.Message)
+	header, blobs, err := parseBackupPayload(dataBackup, userType)
 	if err != nil {
-		fmt.Printf("Error decoding return message: %v\n", err)
 		return nil, err
 	}
 
-	return random, nil
+	return newBackupBlob(header, userType, blobs), nil
 }
 
-func operationBackup(client *http.Client, sessionId string, serverIP string, userType int, data []byte) error {
-	var err error
-
-	payload := ServiceRequest{
-		SessionID: sessionId,
-		Operation: FunctionBackup,
-		Data:      data,
-	}
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		fmt.Printf("Error marshaling JSON: %v\n", err)
-		return err
-	}
-
-	resp, err := client.Post(fmt.Sprintf("https://%s/api/hsm/request", serverIP), "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		fmt.Printf("Error making GET request: %v\n", err)
-		return err
-	}
-	defer resp.Body.Close()
-
-	// Read and print response
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("Error reading response body: %v\n", err)
-		return err
-	}
-
-	var res ServiceResponse
-	err = json.Unmarshal(body, &res)
+// Parse the backup returned by the server
+// It contains the length of header and header, followed by the length and exported key
+// User object has a second length and exported key for the private key of its keypair
+func parseBackupPayload(data []byte, userType int) (string, [][]byte, error) {
+	header, rest, err := readLengthPrefixed(data, "header")
 	if err != nil {
-		fmt.Printf("Error unmarshaling response: %v\n", err)
-		return err
+		return "", nil, err
 	}
 
-	if !res.Success {
-		if res.ErrorMsg != "" {
-			return errors.New(res.ErrorMsg)
+	count := 1
+	if userType == USER_OBJECT {
+		count = 2
+	}
+	blobs := make([][]byte, 0, count)
+	for i := range count {
+		var blob []byte
+		blob, rest, err = readLengthPrefixed(rest, fmt.Sprintf("exported key %d", i+1))
+		if err != nil {
+			return "", nil, err
 		}
-		return errors.New("return auth request is false")
-	}
-	if res.Data.RetCode != SUCCESS {
-		return errors.New(getReturnCodeMessage(res.Data.RetCode))
-	}
-
-	dataBackup, err := base64.StdEncoding.DecodeString(res.Data.Message)
-	if err != nil {
-		fmt.Printf("Error decoding return message: %v\n", err)
-		return err
+		if len(blob) == 0 {
+			return "", nil, fmt.Errorf("%w: exported key %d is empty", ErrMalformedBackup, i+1)
+		}
+		blobs = append(blobs, bytes.Clone(blob))
 	}
 
-	headerLen := binary.BigEndian.Uint16(dataBackup[:2])
-	header := dataBackup[2 : 2+headerLen]
-	stringHeader := string(header)
-
-	exportedLen := binary.BigEndian.Uint16(dataBackup[2+headerLen : 4+headerLen])
-	exported := dataBackup[4+headerLen : 4+headerLen+exportedLen]
-	stringExported := string(exported)
-
-	os.WriteFile(string(data), []byte(stringHeader+"\n"+stringExported), 0644)
-
-	if userType == USER_OBJECT {
-		exportedLen2 := binary.BigEndian.Uint16(dataBackup[4+headerLen+exportedLen : 6+headerLen+exportedLen])
-		exported2 := dataBackup[6+headerLen+exportedLen : 6+headerLen+exportedLen+exportedLen2]
-		stringExported2 := string(exported2)
-		os.WriteFile("priv"+string(data), []byte(stringHeader+"\n"+stringExported2), 0644)
+	if len(rest) > 0 {
+		return "", nil, fmt.Errorf("%w: %d bytes of trailing data", ErrMalformedBackup, len(rest))
 	}
-
-	return nil
+	return string(header), blobs, nil
 }
 
-func operationRestore(client *http.Client, sessionId string, serverIP string, data []byte) error {
-	var err error
-
-	lines, err := os.ReadFile(string(data))
-	if err != nil {
-		return err
+// Read a field prefixed by its length (uint16)
+// Return the field and the remaining data
+func readLengthPrefixed(data []byte, field string) ([]byte, []byte, error) {
+	if len(data) < 2 {
+		return nil, nil, fmt.Errorf("%w: truncated before the length of %s", ErrMalformedBackup, field)
 	}
-	content := bytes.SplitN(lines, []byte("\n"), 2)
-	if len(content) < 2 {
-		return errors.New("invalid backup file format")
+	length := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if length > len(data) {
+		return nil, nil, fmt.Errorf("%w: length of %s is %d but only %d bytes left", ErrMalformedBackup, field, length, len(data))
 	}
-	line := content[1]
+	return data[:length], data[length:], nil
+}
 
+// Restore an exported key
+func operationRestore(ctx context.Context, send requestFunc, blob []byte) error {
 	payload := ServiceRequest{
-		SessionID: sessionId,
 		Operation: FunctionRestore,
-		Data:      line,
-	}
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		fmt.Printf("Error marshaling JSON: %v\n", err)
-		return err
-	}
-
-	resp, err := client.Post(fmt.Sprintf("https://%s/api/hsm/request", serverIP), "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		fmt.Printf("Error making GET request: %v\n", err)
-		return err
-	}
-	defer resp.Body.Close()
-
-	// Read and print response
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("Error reading response body: %v\n", err)
-		return err
-	}
-
-	var res ServiceResponse
-	err = json.Unmarshal(body, &res)
-	if err != nil {
-		fmt.Printf("Error unmarshaling response: %v\n", err)
-		return err
-	}
-
-	if !res.Success {
-		if res.ErrorMsg != "" {
-			return errors.New(res.ErrorMsg)
-		}
-		return errors.New("return auth request is false")
-	}
-	if res.Data.RetCode != SUCCESS {
-		return errors.New(getReturnCodeMessage(res.Data.RetCode))
+		Data:      blob,
 	}
-
-	return nil
+	_, err := send(ctx, payload)
+	return err
 }
 
-func operationDelete(client *http.Client, sessionId string, serverIP string, keyLabel string) error {
-	var err error
-
+func operationDelete(ctx context.Context, send requestFunc, keyLabel string) error {
 	payload := ServiceRequest{
-		SessionID: sessionId,
 		Operation: FunctionDelete,
 		Label:     keyLabel,
 	}
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		fmt.Printf("Error marshaling JSON: %v\n", err)
-		return err
-	}
-
-	resp, err := client.Post(fmt.Sprintf("https://%s/api/hsm/request", serverIP), "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		fmt.Printf("Error making GET request: %v\n", err)
-		return err
-	}
-	defer resp.Body.Close()
-
-	// Read and print response
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("Error reading response body: %v\n", err)
-		return err
-	}
-
-	var res ServiceResponse
-	err = json.Unmarshal(body, &res)
-	if err != nil {
-		fmt.Printf("Error unmarshaling response: %v\n", err)
-		return err
-	}
-
-	if !res.Success {
-		if res.ErrorMsg != "" {
-			return errors.New(res.ErrorMsg)
-		}
-		return errors.New("return auth request is false")
-	}
-	if res.Data.RetCode != SUCCESS {
-		return errors.New(getReturnCodeMessage(res.Data.RetCode))
-	}
-
-	return nil
+	_, err := send(ctx, payload)
+	return err
 }
 
-func operationGenKeySym(client *http.Client, sessionId string, serverIP string, keyLabel string) error {
-	var err error
-
+func operationGenKeySym(ctx context.Context, send requestFunc, keyLabel string) error {
 	payload := ServiceRequest{
-		SessionID: sessionId,
 		Operation: FunctionGenKeySym,
 		Label:     keyLabel,
 	}
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		fmt.Printf("Error marshaling JSON: %v\n", err)
-		return err
-	}
-
-	resp, err := client.Post(fmt.Sprintf("https://%s/api/hsm/request", serverIP), "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		fmt.Printf("Error making GET request: %v\n", err)
-		return err
-	}
-	defer resp.Body.Close()
-
-	// Read and print response
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("Error reading response body: %v\n", err)
-		return err
-	}
-
-	var res ServiceResponse
-	err = json.Unmarshal(body, &res)
-	if err != nil {
-		fmt.Printf("Error unmarshaling response: %v\n", err)
-		return err
-	}
-
-	if !res.Success {
-		if res.ErrorMsg != "" {
-			return errors.New(res.ErrorMsg)
-		}
-		return errors.New("return auth request is false")
-	}
-	if res.Data.RetCode != SUCCESS {
-		return errors.New(getReturnCodeMessage(res.Data.RetCode))
-	}
-
-	return nil
+	_, err := send(ctx, payload)
+	return err
 }
 
-func operationGenKeyAsym(client *http.Client, sessionId string, serverIP string, pubLabel, privLabel string) error {
-	var err error
-
+func operationGenKeyAsym(ctx context.Context, send requestFunc, pubLabel, privLabel string) error {
 	payload := ServiceRequest{
-		SessionID: sessionId,
 		Operation: FunctionGenKeyAsym,
 		Label:     fmt.Sprintf("%s;%s", pubLabel, privLabel),
 	}
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		fmt.Printf("Error marshaling JSON: %v\n", err)
-		return err
-	}
-
-	resp, err := client.Post(fmt.Sprintf("https://%s/api/hsm/request", serverIP), "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		fmt.Printf("Error making GET request: %v\n", err)
-		return err
-	}
-	defer resp.Body.Close()
-
-	// Read and print response
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("Error reading response body: %v\n", err)
-		return err
-	}
-
-	var res ServiceResponse
-	err = json.Unmarshal(body, &res)
-	if err != nil {
-		fmt.Printf("Error unmarshaling response: %v\n", err)
-		return err
-	}
-
-	if !res.Success {
-		if res.ErrorMsg != "" {
-			return errors.New(res.ErrorMsg)
-		}
-		return errors.New("return auth request is false")
-	}
-	if res.Data.RetCode != SUCCESS {
-		return errors.New(getReturnCodeMessage(res.Data.RetCode))
-	}
-
-	return nil
+	_, err := send(ctx, payload)
+	return err
 }
 
-func operationSetIV(client *http.Client, sessionId string, serverIP string, data []byte) error {
-	var err error
-
+func operationSetIV(ctx context.Context, send requestFunc, data []byte) error {
 	payload := ServiceRequest{
-		SessionID: sessionId,
 		Operation: FunctionSetIV,
 		Data:      data,
 	}
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		fmt.Printf("Error marshaling JSON: %v\n", err)
-		return err
-	}
-
-	resp, err := client.Post(fmt.Sprintf("https://%s/api/hsm/request", serverIP), "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		fmt.Printf("Error making GET request: %v\n", err)
-		return err
-	}
-	defer resp.Body.Close()
+	_, err := send(ctx, payload)
+	return err
+}
 
-	// Read and print response
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("Error reading response body: %v\n", err)
-		return err
+// Return the DER encoded public key (SubjectPublicKeyInfo) of the keypair
+func operationGetPubKey(ctx context.Context, send requestFunc, keyLabel string) ([]byte, error) {
+	payload := ServiceRequest{
+		Operation: FunctionGetPubKey,
+		Label:     keyLabel,
 	}
-
-	var res ServiceResponse
-	err = json.Unmarshal(body, &res)
+	res, err := send(ctx, payload)
 	if err != nil {
-		fmt.Printf("Error unmarshaling response: %v\n", err)
-		return err
-	}
-
-	if !res.Success {
-		if res.ErrorMsg != "" {
-			return errors.New(res.ErrorMsg)
-		}
-		return errors.New("return auth request is false")
-	}
-	if res.Data.RetCode != SUCCESS {
-		return errors.New(getReturnCodeMessage(res.Data.RetCode))
+		return nil, err
 	}
 
-	return nil
-}
-
-func getReturnCodeMessage(code int) string {
-	if msg, exists := mapRetCodeToString[code]; exists {
-		return msg
-	}
-	return "Unknown return"
+	return decodeMessage(res)
 }
 
 func uint16ToBytes(num uint16) []byte {
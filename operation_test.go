@@ -0,0 +1,100 @@
+package ksema
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+)
+
+// Golden vector of the backup returned by the server
+type backupPayloadVector struct {
+	Name      string   `json:"name"`
+	UserType  int      `json:"userType"`
+	Payload   string   `json:"payload"`
+	Header    string   `json:"header"`
+	Blobs     []string `json:"blobs"`
+	Malformed bool     `json:"malformed"`
+}
+
+func loadBackupPayloadVectors(t testing.TB) []backupPayloadVector {
+	data, err := os.ReadFile("testdata/backup_payload.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors []backupPayloadVector
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	return vectors
+}
+
+func decodeHex(t testing.TB, s string) []byte {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParseBackupPayload(t *testing.T) {
+	for _, v := range loadBackupPayloadVectors(t) {
+		t.Run(v.Name, func(t *testing.T) {
+			header, blobs, err := parseBackupPayload(decodeHex(t, v.Payload), v.UserType)
+			if v.Malformed {
+				if !errors.Is(err, ErrMalformedBackup) {
+					t.Fatalf("got %v, want ErrMalformedBackup", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if header != v.Header {
+				t.Fatalf("got header %q, want %q", header, v.Header)
+			}
+			if len(blobs) != len(v.Blobs) {
+				t.Fatalf("got %d blobs, want %d", len(blobs), len(v.Blobs))
+			}
+			for i, blob := range blobs {
+				if want := decodeHex(t, v.Blobs[i]); !bytes.Equal(blob, want) {
+					t.Fatalf("blob %d is %x, want %x", i, blob, want)
+				}
+			}
+		})
+	}
+}
+
+func FuzzParseBackupPayload(f *testing.F) {
+	for _, v := range loadBackupPayloadVectors(f) {
+		f.Add(decodeHex(f, v.Payload), v.UserType)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, userType int) {
+		header, blobs, err := parseBackupPayload(data, userType)
+		if err != nil {
+			if !errors.Is(err, ErrMalformedBackup) {
+				t.Fatalf("error %v is not ErrMalformedBackup", err)
+			}
+			return
+		}
+
+		// A parsed payload is encoded back to the same bytes
+		encoded := binary.BigEndian.AppendUint16(nil, uint16(len(header)))
+		encoded = append(encoded, header...)
+		for _, blob := range blobs {
+			if len(blob) == 0 {
+				t.Fatal("empty blob parsed")
+			}
+			encoded = binary.BigEndian.AppendUint16(encoded, uint16(len(blob)))
+			encoded = append(encoded, blob...)
+		}
+		if !bytes.Equal(encoded, data) {
+			t.Fatalf("parsed %q %x from %x", header, blobs, data)
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package ksema
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Credentials hold the secrets used for the key exchange
+//
+// PIN is only needed for user slot
+type Credentials struct {
+	PassKey string
+	APIKey  string
+	PIN     string
+}
+
+// Option configure the Ksema object created by NewWithOptions
+type Option func(*config)
+
+type config struct {
+	httpClient          *http.Client
+	rootCAs             *x509.CertPool
+	serverName          string
+	timeout             time.Duration
+	curves              []tls.CurveID
+	insecureSkipVerify  bool
+	reauthHook          func(ReauthEvent)
+	retry               RetryPolicy
+	strategy            Strategy
+	healthCheckInterval *time.Duration
+	coolOff             time.Duration
+	logger              *slog.Logger
+	instrumentation     Instrumentation
+	keepAlive           time.Duration
+	sessionTTL          time.Duration
+	interceptors        []Interceptor
+	rateLimit           *RateLimit
+	operationLimits     map[string]RateLimit
+	labelLimits         map[string]RateLimit
+	quotas              map[string]Quota
+	quotaWarning        func(QuotaWarning)
+	usageStore          UsageStore
+	breaker             *CircuitBreaker
+	batchConcurrency    int
+	streamChunkSize     int
+	dekCacheTTL         time.Duration
+}
+
+func defaultConfig() *config {
+	return &config{
+		coolOff:          DEFAULT_COOL_OFF,
+		batchConcurrency: DEFAULT_BATCH_CONCURRENCY,
+		streamChunkSize:  DEFAULT_STREAM_CHUNK_SIZE,
+		logger:           slog.New(slog.DiscardHandler),
+		curves: []tls.CurveID{
+			tls.X25519MLKEM768,
+		},
+	}
+}
+
+// WithRootCAs set the CA bundle used to verify the server certificate
+// If not set, the system pool is used
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(c *config) {
+		c.rootCAs = pool
+	}
+}
+
+// WithServerName set the name used to verify the server certificate
+// Useful when connecting with an IP address
+func WithServerName(name string) Option {
+	return func(c *config) {
+		c.serverName = name
+	}
+}
+
+// WithHTTPClient use the given client for every request
+//
+// The TLS options are ignored since the client is used as is
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *config) {
+		c.httpClient = client
+	}
+}
+
+// WithTimeout set the timeout of each HTTP request
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.timeout = timeout
+	}
+}
+
+// WithCurvePreferences override the key exchange curves of the TLS handshake
+// The default is X25519MLKEM768
+func WithCurvePreferences(curves ...tls.CurveID) Option {
+	return func(c *config) {
+		c.curves = curves
+	}
+}
+
+// WithInsecureSkipVerify disable the verification of the server certificate
+//
+// This should only be used for testing or server with self-signed certificate
+func WithInsecureSkipVerify() Option {
+	return func(c *config) {
+		c.insecureSkipVerify = true
+	}
+}
+
+// Build the http client from the config
+func (c *config) newHTTPClient() *http.Client {
+	if c.httpClient != nil {
+		if c.timeout > 0 && c.httpClient.Timeout == 0 {
+			client := *c.httpClient
+			client.Timeout = c.timeout
+			return &client
+		}
+		return c.httpClient
+	}
+
+	return &http.Client{
+		Timeout: c.timeout,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConnsPerHost: max(c.batchConcurrency, http.DefaultMaxIdleConnsPerHost),
+			TLSClientConfig: &tls.Config{
+				RootCAs:            c.rootCAs,
+				ServerName:         c.serverName,
+				InsecureSkipVerify: c.insecureSkipVerify,
+				CurvePreferences:   c.curves,
+			},
+		},
+	}
+}
@@ -0,0 +1,24 @@
+module github.com/suhailiealx/ksema-sdk-go/otelksema
+
+go 1.24.0
+
+require (
+	github.com/suhailiealx/ksema-sdk-go v0.0.0-20261017032436-de0df5f03393
+	go.opentelemetry.io/otel v1.37.0
+	go.opentelemetry.io/otel/metric v1.37.0
+	go.opentelemetry.io/otel/sdk v1.37.0
+	go.opentelemetry.io/otel/sdk/metric v1.37.0
+	go.opentelemetry.io/otel/trace v1.37.0
+)
+
+require (
+	github.com/go-logr/logr v1.4.3 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	go.opentelemetry.io/auto/sdk v1.1.0 // indirect
+	golang.org/x/crypto v0.48.0 // indirect
+	golang.org/x/sys v0.41.0 // indirect
+)
+
+// Use the core module of the repository for local development, ignored by the users of this module
+replace github.com/suhailiealx/ksema-sdk-go => ../
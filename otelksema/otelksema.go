@@ -0,0 +1,176 @@
+// Package otelksema instrument the Ksema client with OpenTelemetry tracing and metrics
+//
+// It is a separate module so the core SDK does not depend on OpenTelemetry
+package otelksema
+
+import (
+	"context"
+	"net/http"
+
+	ksema "github.com/suhailiealx/ksema-sdk-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name of the tracer and meter
+const ScopeName = "github.com/suhailiealx/ksema-sdk-go/otelksema"
+
+// Attribute keys
+const (
+	AttrOperation = attribute.Key("ksema.operation")
+	AttrLabel     = attribute.Key("ksema.label")
+	AttrUserType  = attribute.Key("ksema.user_type")
+	AttrRetCode   = attribute.Key("ksema.ret_code")
+	AttrAttempts  = attribute.Key("ksema.attempts")
+	AttrEndpoint  = attribute.Key("server.address")
+)
+
+// Option configure the instrumentation
+type Option func(*config)
+
+type config struct {
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+	propagator     propagation.TextMapPropagator
+}
+
+// WithTracerProvider set the tracer provider
+// Default is the global provider
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tracerProvider = provider
+	}
+}
+
+// WithMeterProvider set the meter provider
+// Default is the global provider
+func WithMeterProvider(provider metric.MeterProvider) Option {
+	return func(c *config) {
+		c.meterProvider = provider
+	}
+}
+
+// WithPropagator set the propagator of the trace context to the server
+// Default is the W3C trace context, use otel.GetTextMapPropagator() to follow the global propagator
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(c *config) {
+		c.propagator = propagator
+	}
+}
+
+type instrumentation struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+
+	requests     metric.Int64Counter
+	errors       metric.Int64Counter
+	duration     metric.Float64Histogram
+	requestSize  metric.Int64Histogram
+	responseSize metric.Int64Histogram
+}
+
+// New return the OpenTelemetry implementation of ksema.Instrumentation
+func New(opts ...Option) (ksema.Instrumentation, error) {
+	cfg := &config{
+		tracerProvider: otel.GetTracerProvider(),
+		meterProvider:  otel.GetMeterProvider(),
+		propagator:     propagation.TraceContext{},
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	meter := cfg.meterProvider.Meter(ScopeName)
+	i := &instrumentation{
+		tracer:     cfg.tracerProvider.Tracer(ScopeName),
+		propagator: cfg.propagator,
+	}
+
+	var err error
+	if i.requests, err = meter.Int64Counter("ksema.client.requests",
+		metric.WithDescription("Number of requests to the Ksema server"),
+		metric.WithUnit("{request}")); err != nil {
+		return nil, err
+	}
+	if i.errors, err = meter.Int64Counter("ksema.client.errors",
+		metric.WithDescription("Number of failed requests to the Ksema server by return code"),
+		metric.WithUnit("{request}")); err != nil {
+		return nil, err
+	}
+	if i.duration, err = meter.Float64Histogram("ksema.client.duration",
+		metric.WithDescription("Duration of requests to the Ksema server including retries"),
+		metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	if i.requestSize, err = meter.Int64Histogram("ksema.client.request.size",
+		metric.WithDescription("Size of the request data"),
+		metric.WithUnit("By")); err != nil {
+		return nil, err
+	}
+	if i.responseSize, err = meter.Int64Histogram("ksema.client.response.size",
+		metric.WithDescription("Size of the response data"),
+		metric.WithUnit("By")); err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
+// Instrument return the option enabling OpenTelemetry on the Ksema client
+func Instrument(opts ...Option) (ksema.Option, error) {
+	i, err := New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return ksema.WithInstrumentation(i), nil
+}
+
+func (i *instrumentation) Start(ctx context.Context, info ksema.RequestInfo) context.Context {
+	ctx, _ = i.tracer.Start(ctx, "ksema "+info.Op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			AttrOperation.String(info.Op),
+			AttrLabel.String(info.Label),
+			AttrUserType.Int(info.UserType),
+		),
+	)
+	return ctx
+}
+
+func (i *instrumentation) Inject(ctx context.Context, header http.Header) {
+	i.propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+func (i *instrumentation) End(ctx context.Context, info ksema.RequestInfo, result ksema.ResultInfo) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(
+		AttrRetCode.Int(result.RetCode),
+		AttrAttempts.Int(result.Attempts),
+		AttrEndpoint.String(result.Endpoint),
+	)
+	if result.Err != nil {
+		span.RecordError(result.Err)
+		span.SetStatus(codes.Error, result.Err.Error())
+	}
+	span.End()
+
+	opAttr := metric.WithAttributes(AttrOperation.String(info.Op))
+	resultAttr := metric.WithAttributes(
+		AttrOperation.String(info.Op),
+		AttrRetCode.Int(result.RetCode),
+		AttrEndpoint.String(result.Endpoint),
+	)
+
+	i.requests.Add(ctx, 1, resultAttr)
+	i.duration.Record(ctx, result.Duration.Seconds(), resultAttr)
+	i.requestSize.Record(ctx, int64(info.RequestSize), opAttr)
+	if result.Err != nil {
+		i.errors.Add(ctx, 1, resultAttr)
+	} else {
+		i.responseSize.Record(ctx, int64(result.ResponseSize), opAttr)
+	}
+}
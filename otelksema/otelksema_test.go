@@ -0,0 +1,187 @@
+package otelksema_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	ksema "github.com/suhailiealx/ksema-sdk-go"
+	"github.com/suhailiealx/ksema-sdk-go/ksematest"
+	"github.com/suhailiealx/ksema-sdk-go/otelksema"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// Transport keeping the traceparent header of the service requests
+type headerTransport struct {
+	base http.RoundTripper
+
+	mu          sync.Mutex
+	traceparent []string
+}
+
+func (h *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasSuffix(req.URL.Path, "/request") {
+		h.mu.Lock()
+		h.traceparent = append(h.traceparent, req.Header.Get("traceparent"))
+		h.mu.Unlock()
+	}
+	return h.base.RoundTrip(req)
+}
+
+type testKsema struct {
+	*ksema.Ksema
+	srv       *ksematest.Server
+	spans     *tracetest.SpanRecorder
+	tracer    *sdktrace.TracerProvider
+	metrics   *sdkmetric.ManualReader
+	transport *headerTransport
+}
+
+func newTestKsema(t *testing.T) *testKsema {
+	t.Helper()
+	srv := ksematest.NewServer()
+	t.Cleanup(srv.Close)
+
+	tk := &testKsema{
+		srv:       srv,
+		spans:     tracetest.NewSpanRecorder(),
+		metrics:   sdkmetric.NewManualReader(),
+		transport: &headerTransport{base: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: srv.CertPool()}}},
+	}
+	tk.tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(tk.spans))
+	instrument, err := otelksema.Instrument(
+		otelksema.WithTracerProvider(tk.tracer),
+		otelksema.WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(tk.metrics))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tk.Ksema, err = srv.NewKsema(ksematest.UserBrigade, instrument,
+		ksema.WithHTTPClient(&http.Client{Transport: tk.transport}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tk.Close(context.Background()) })
+	return tk
+}
+
+// Return the ended spans of the operation
+func (tk *testKsema) ended(op string) []sdktrace.ReadOnlySpan {
+	var spans []sdktrace.ReadOnlySpan
+	for _, span := range tk.spans.Ended() {
+		if span.Name() == "ksema "+op {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+// Return the data points of the counter
+func (tk *testKsema) counter(t *testing.T, name string) []metricdata.DataPoint[int64] {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := tk.metrics.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name == name {
+				return m.Data.(metricdata.Sum[int64]).DataPoints
+			}
+		}
+	}
+	return nil
+}
+
+func checkAttr(t *testing.T, attrs attribute.Set, key attribute.Key, want attribute.Value) {
+	t.Helper()
+	got, ok := attrs.Value(key)
+	if !ok || got != want {
+		t.Fatalf("attribute %s is %v, want %v", key, got.Emit(), want.Emit())
+	}
+}
+
+func TestSpan(t *testing.T) {
+	tk := newTestKsema(t)
+	if err := tk.GenKey("AES01", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, parent := tk.tracer.Tracer("test").Start(context.Background(), "parent")
+	if _, err := tk.EncryptContext(ctx, []byte("data"), "AES01"); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	spans := tk.ended(ksema.FunctionEncrypt)
+	if len(spans) != 1 {
+		t.Fatalf("got %d encrypt spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Fatal("span is not a child of the context span")
+	}
+	attrs := attribute.NewSet(span.Attributes()...)
+	checkAttr(t, attrs, otelksema.AttrOperation, attribute.StringValue(ksema.FunctionEncrypt))
+	checkAttr(t, attrs, otelksema.AttrLabel, attribute.StringValue("AES01"))
+	checkAttr(t, attrs, otelksema.AttrUserType, attribute.IntValue(ksematest.UserBrigade))
+	checkAttr(t, attrs, otelksema.AttrRetCode, attribute.IntValue(ksema.SUCCESS))
+	checkAttr(t, attrs, otelksema.AttrAttempts, attribute.IntValue(1))
+	checkAttr(t, attrs, otelksema.AttrEndpoint, attribute.StringValue(tk.srv.Addr()))
+
+	// The server receive the context of the request span
+	tk.transport.mu.Lock()
+	traceparent := tk.transport.traceparent[len(tk.transport.traceparent)-1]
+	tk.transport.mu.Unlock()
+	want := "00-" + span.SpanContext().TraceID().String() + "-" + span.SpanContext().SpanID().String() + "-01"
+	if traceparent != want {
+		t.Fatalf("traceparent is %q, want %q", traceparent, want)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tk := newTestKsema(t)
+	if err := tk.GenKey("AES01", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tk.srv.InjectRetCode(ksema.FunctionEncrypt, ksema.MAXUSAGE, 2)
+	for range 3 {
+		tk.Encrypt([]byte("data"), "AES01")
+	}
+
+	for _, span := range tk.ended(ksema.FunctionEncrypt)[:2] {
+		if span.Status().Code != codes.Error {
+			t.Fatalf("span status is %v, want error", span.Status().Code)
+		}
+		checkAttr(t, attribute.NewSet(span.Attributes()...), otelksema.AttrRetCode, attribute.IntValue(ksema.MAXUSAGE))
+	}
+
+	errs := tk.counter(t, "ksema.client.errors")
+	if len(errs) != 1 {
+		t.Fatalf("got %d error data points, want 1", len(errs))
+	}
+	checkAttr(t, errs[0].Attributes, otelksema.AttrRetCode, attribute.IntValue(ksema.MAXUSAGE))
+	if errs[0].Value != 2 {
+		t.Fatalf("counted %d errors, want 2", errs[0].Value)
+	}
+
+	var requests int64
+	for _, dp := range tk.counter(t, "ksema.client.requests") {
+		if op, _ := dp.Attributes.Value(otelksema.AttrOperation); op.AsString() == ksema.FunctionEncrypt {
+			requests += dp.Value
+		}
+	}
+	if requests != 3 {
+		t.Fatalf("counted %d encrypt requests, want 3", requests)
+	}
+}
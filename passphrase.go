@@ -0,0 +1,159 @@
+package ksema
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Default scrypt parameters of the passphrase protected backup
+const (
+	DEFAULT_SCRYPT_N = 1 << 15
+	DEFAULT_SCRYPT_R = 8
+	DEFAULT_SCRYPT_P = 1
+
+	// Limits of the parameters accepted when reading a backup
+	MAX_SCRYPT_N      = 1 << 22
+	MAX_SCRYPT_MEMORY = 1024 * 1024 * 1024
+
+	backupKDF      = "scrypt"
+	backupCipher   = "AES-256-GCM"
+	backupSaltSize = 16
+)
+
+var (
+	// ErrPassphraseRequired is returned when reading a passphrase protected backup without passphrase
+	ErrPassphraseRequired = errors.New("backup is protected by a passphrase")
+	// ErrInvalidPassphrase is returned when the passphrase does not decrypt the backup
+	ErrInvalidPassphrase = errors.New("invalid backup passphrase or corrupt backup")
+)
+
+// ScryptParams are the cost parameters of scrypt, see golang.org/x/crypto/scrypt
+type ScryptParams struct {
+	N int
+	R int
+	P int
+}
+
+// Encryption of the passphrase protected backup, written in the backup file
+type backupEncryption struct {
+	KDF    string `json:"kdf"`
+	Salt   []byte `json:"salt"`
+	N      int    `json:"n"`
+	R      int    `json:"r"`
+	P      int    `json:"p"`
+	Cipher string `json:"cipher"`
+	Nonce  []byte `json:"nonce"`
+}
+
+// Encrypt the blobs with the key derived from the passphrase
+// The additional data bind the ciphertext to the header of the file
+func encryptBackup(passphrase string, params ScryptParams, aad []byte, blobs [][]byte) (*backupEncryption, []byte, error) {
+	enc := &backupEncryption{
+		KDF:    backupKDF,
+		Salt:   make([]byte, backupSaltSize),
+		N:      params.N,
+		R:      params.R,
+		P:      params.P,
+		Cipher: backupCipher,
+	}
+	if _, err := rand.Read(enc.Salt); err != nil {
+		return nil, nil, err
+	}
+
+	aead, err := enc.aead(passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	enc.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(enc.Nonce); err != nil {
+		return nil, nil, err
+	}
+
+	var plainText []byte
+	for _, blob := range blobs {
+		plainText = binary.BigEndian.AppendUint32(plainText, uint32(len(blob)))
+		plainText = append(plainText, blob...)
+	}
+	defer clear(plainText)
+
+	return enc, aead.Seal(nil, enc.Nonce, plainText, aad), nil
+}
+
+// Decrypt the blobs with the key derived from the passphrase
+func (enc *backupEncryption) decrypt(passphrase string, aad, cipherText []byte) ([][]byte, error) {
+	aead, err := enc.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(enc.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("%w: invalid nonce size", ErrInvalidBackup)
+	}
+
+	plainText, err := aead.Open(nil, enc.Nonce, cipherText, aad)
+	if err != nil {
+		return nil, ErrInvalidPassphrase
+	}
+
+	var blobs [][]byte
+	for len(plainText) > 0 {
+		if len(plainText) < 4 {
+			return nil, fmt.Errorf("%w: truncated encrypted key", ErrInvalidBackup)
+		}
+		length := binary.BigEndian.Uint32(plainText)
+		plainText = plainText[4:]
+		if uint64(length) > uint64(len(plainText)) {
+			return nil, fmt.Errorf("%w: truncated encrypted key", ErrInvalidBackup)
+		}
+		blobs = append(blobs, plainText[:length])
+		plainText = plainText[length:]
+	}
+	return blobs, nil
+}
+
+// Derive the key from the passphrase and return the cipher
+func (enc *backupEncryption) aead(passphrase string) (cipher.AEAD, error) {
+	if enc.KDF != backupKDF {
+		return nil, fmt.Errorf("%w: unsupported KDF %q", ErrInvalidBackup, enc.KDF)
+	}
+	if enc.Cipher != backupCipher {
+		return nil, fmt.Errorf("%w: unsupported cipher %q", ErrInvalidBackup, enc.Cipher)
+	}
+	if err := enc.checkParams(); err != nil {
+		return nil, err
+	}
+
+	key, err := scrypt.Key([]byte(passphrase), enc.Salt, enc.N, enc.R, enc.P, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	defer clear(key)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Reject the parameters using too much memory, the file may come from untrusted storage
+func (enc *backupEncryption) checkParams() error {
+	if len(enc.Salt) < backupSaltSize {
+		return fmt.Errorf("%w: salt is too short", ErrInvalidBackup)
+	}
+	if enc.N <= 1 || enc.N&(enc.N-1) != 0 || enc.N > MAX_SCRYPT_N {
+		return fmt.Errorf("%w: invalid scrypt N %d", ErrInvalidBackup, enc.N)
+	}
+	if enc.R <= 0 || enc.P <= 0 || uint64(enc.R)*uint64(enc.P) >= 1<<30 {
+		return fmt.Errorf("%w: invalid scrypt r %d and p %d", ErrInvalidBackup, enc.R, enc.P)
+	}
+	if 128*uint64(enc.R)*uint64(enc.N) > MAX_SCRYPT_MEMORY {
+		return fmt.Errorf("%w: scrypt parameters use more than %d bytes", ErrInvalidBackup, MAX_SCRYPT_MEMORY)
+	}
+	return nil
+}
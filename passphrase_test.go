@@ -0,0 +1,129 @@
+package ksema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Cheap parameters to keep the tests fast
+var testScryptParams = ScryptParams{N: 1 << 10, R: 8, P: 1}
+
+func encodeProtectedBackup(t *testing.T) (*BackupBlob, []byte) {
+	t.Helper()
+	backup := newBackupBlob("KSEMA;SYM;AES01", 3, [][]byte{[]byte("exported key")})
+	backup.Created = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	backup.Server = "127.0.0.1:8443"
+
+	var buf bytes.Buffer
+	if _, err := backup.Encode(&buf, WithPassphrase("passphrase"), WithScryptParams(testScryptParams)); err != nil {
+		t.Fatal(err)
+	}
+	return backup, buf.Bytes()
+}
+
+func TestPassphraseRoundTrip(t *testing.T) {
+	backup, data := encodeProtectedBackup(t)
+	if bytes.Contains(data, []byte("exported key")) {
+		t.Fatal("exported key written in clear")
+	}
+
+	read, err := ReadBackup(bytes.NewReader(data), WithPassphrase("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Header != backup.Header || len(read.Blobs) != 1 || !bytes.Equal(read.Blobs[0], backup.Blobs[0]) {
+		t.Fatalf("got header %q and blobs %q", read.Header, read.Blobs)
+	}
+
+	if _, err := ReadBackup(bytes.NewReader(data), WithPassphrase("wrong passphrase")); !errors.Is(err, ErrInvalidPassphrase) {
+		t.Fatalf("wrong passphrase: got %v, want ErrInvalidPassphrase", err)
+	}
+	if _, err := ReadBackup(bytes.NewReader(data)); !errors.Is(err, ErrPassphraseRequired) {
+		t.Fatalf("missing passphrase: got %v, want ErrPassphraseRequired", err)
+	}
+}
+
+func TestPassphraseTampering(t *testing.T) {
+	_, data := encodeProtectedBackup(t)
+
+	flip := func(b []byte) {
+		b[0] ^= 1
+	}
+	tests := []struct {
+		name   string
+		tamper func(*backupFile)
+	}{
+		{"scrypt N", func(f *backupFile) { f.Encryption.N *= 2 }},
+		{"salt", func(f *backupFile) { flip(f.Encryption.Salt) }},
+		{"nonce", func(f *backupFile) { flip(f.Encryption.Nonce) }},
+		{"ciphertext", func(f *backupFile) { flip(f.Ciphertext) }},
+		{"server", func(f *backupFile) { f.Server = "10.0.0.1:8443" }},
+		{"created", func(f *backupFile) { f.Created = f.Created.Add(time.Hour) }},
+		{"user type", func(f *backupFile) { f.UserType = 4 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var file backupFile
+			if err := json.Unmarshal(data, &file); err != nil {
+				t.Fatal(err)
+			}
+			tt.tamper(&file)
+			// The checksum is not keyed, it is updated as an attacker would do
+			file.Checksum = file.checksum([][]byte{file.Ciphertext})
+			tampered, err := json.Marshal(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := ReadBackup(bytes.NewReader(tampered), WithPassphrase("passphrase")); !errors.Is(err, ErrInvalidPassphrase) {
+				t.Fatalf("got %v, want ErrInvalidPassphrase", err)
+			}
+		})
+	}
+}
+
+func TestPassphraseCheckParams(t *testing.T) {
+	salt := make([]byte, backupSaltSize)
+	tests := []struct {
+		name string
+		enc  backupEncryption
+	}{
+		{"N too large", backupEncryption{Salt: salt, N: MAX_SCRYPT_N * 2, R: 1, P: 1}},
+		{"N not a power of 2", backupEncryption{Salt: salt, N: 1000, R: 8, P: 1}},
+		{"N too small", backupEncryption{Salt: salt, N: 1, R: 8, P: 1}},
+		{"memory too large", backupEncryption{Salt: salt, N: 1 << 20, R: 16, P: 1}},
+		{"r zero", backupEncryption{Salt: salt, N: 1 << 10, R: 0, P: 1}},
+		{"r and p too large", backupEncryption{Salt: salt, N: 2, R: 1 << 15, P: 1 << 15}},
+		{"short salt", backupEncryption{Salt: salt[:8], N: 1 << 10, R: 8, P: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.enc.checkParams(); !errors.Is(err, ErrInvalidBackup) {
+				t.Fatalf("got %v, want ErrInvalidBackup", err)
+			}
+		})
+	}
+
+	params := backupEncryption{Salt: salt, N: DEFAULT_SCRYPT_N, R: DEFAULT_SCRYPT_R, P: DEFAULT_SCRYPT_P}
+	if err := params.checkParams(); err != nil {
+		t.Fatalf("default parameters rejected: %v", err)
+	}
+
+	// The parameters are checked before the key derivation
+	_, data := encodeProtectedBackup(t)
+	var file backupFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	file.Encryption.N = 1 << 30
+	oversized, err := json.Marshal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadBackup(bytes.NewReader(oversized), WithPassphrase("passphrase")); !errors.Is(err, ErrInvalidBackup) {
+		t.Fatalf("oversized scrypt N: got %v, want ErrInvalidBackup", err)
+	}
+}
@@ -0,0 +1,160 @@
+package ksema
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+)
+
+// JWK is the JSON Web Key of a public key, see RFC 7517
+// Kid is the thumbprint of the key, see RFC 7638
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// Return the public key of the keypair
+// The label can be the public or private label of the keypair
+// GETPUBKEY is not in the documented operations of the server, it is assumed to return the DER SubjectPublicKeyInfo
+//
+// User object does not need to specified the key label used, except for user slot
+func (k *Ksema) PublicKey(keyLabel string) (crypto.PublicKey, error) {
+	return k.PublicKeyContext(context.Background(), keyLabel)
+}
+
+// PublicKeyContext is like PublicKey but bound to the context
+func (k *Ksema) PublicKeyContext(ctx context.Context, keyLabel string) (crypto.PublicKey, error) {
+	if k.getUserType() > USER_OBJECT && keyLabel == "" {
+		return nil, ErrNoKeyLabel
+	}
+
+	der, err := operationGetPubKey(ctx, k.request, keyLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	public, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key: %w", err)
+	}
+	return public, nil
+}
+
+// Encode the public key as DER SubjectPublicKeyInfo
+func MarshalPublicKeyDER(pub crypto.PublicKey) ([]byte, error) {
+	return x509.MarshalPKIXPublicKey(pub)
+}
+
+// Encode the public key as PEM block "PUBLIC KEY"
+func MarshalPublicKeyPEM(pub crypto.PublicKey) ([]byte, error) {
+	der, err := MarshalPublicKeyDER(pub)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// Return the JWK of the public key with the thumbprint as kid
+// Supported keys are ECDSA (P-256, P-384, P-521), RSA and Ed25519
+func PublicKeyJWK(pub crypto.PublicKey) (*JWK, error) {
+	var jwk JWK
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		crv, size, err := jwkCurve(pub.Curve)
+		if err != nil {
+			return nil, err
+		}
+		jwk = JWK{
+			Kty: "EC",
+			Crv: crv,
+			X:   encodeJWKInt(pub.X, size),
+			Y:   encodeJWKInt(pub.Y, size),
+		}
+	case *rsa.PublicKey:
+		jwk = JWK{
+			Kty: "RSA",
+			N:   encodeJWKInt(pub.N, 0),
+			E:   encodeJWKInt(big.NewInt(int64(pub.E)), 0),
+		}
+	case ed25519.PublicKey:
+		jwk = JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(pub),
+		}
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+
+	kid, err := jwk.Thumbprint()
+	if err != nil {
+		return nil, err
+	}
+	jwk.Kid = kid
+	return &jwk, nil
+}
+
+// Encode the public key as JSON Web Key
+func MarshalPublicKeyJWK(pub crypto.PublicKey) ([]byte, error) {
+	jwk, err := PublicKeyJWK(pub)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jwk)
+}
+
+// Return the SHA-256 thumbprint of the key, base64url encoded
+// Only the required members are hashed in lexicographic order, see RFC 7638
+func (j *JWK) Thumbprint() (string, error) {
+	var members string
+	switch j.Kty {
+	case "EC":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, j.Crv, j.X, j.Y)
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, j.E, j.N)
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, j.Crv, j.X)
+	default:
+		return "", fmt.Errorf("unsupported key type %q", j.Kty)
+	}
+
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// Return the JWK name and the coordinate size of the curve
+func jwkCurve(curve elliptic.Curve) (string, int, error) {
+	switch curve {
+	case elliptic.P256():
+		return "P-256", 32, nil
+	case elliptic.P384():
+		return "P-384", 48, nil
+	case elliptic.P521():
+		return "P-521", 66, nil
+	}
+	return "", 0, fmt.Errorf("unsupported curve %s", curve.Params().Name)
+}
+
+// Encode the integer as base64url, left padded to size if size > 0
+func encodeJWKInt(n *big.Int, size int) string {
+	b := n.Bytes()
+	if size > len(b) {
+		b = n.FillBytes(make([]byte, size))
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
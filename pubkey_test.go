@@ -0,0 +1,156 @@
+package ksema_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"testing"
+
+	"github.com/suhailiealx/ksema-sdk-go"
+	"github.com/suhailiealx/ksema-sdk-go/ksematest"
+)
+
+func decodeBase64URL(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// Example of RFC 7638 section 3.1
+func TestThumbprintRSA(t *testing.T) {
+	const (
+		n    = "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"
+		want = "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"
+	)
+
+	jwk := ksema.JWK{Kty: "RSA", N: n, E: "AQAB"}
+	if kid, err := jwk.Thumbprint(); err != nil || kid != want {
+		t.Fatalf("thumbprint is %q (%v), want %q", kid, err, want)
+	}
+
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(decodeBase64URL(t, n)), E: 65537}
+	encoded, err := ksema.PublicKeyJWK(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *encoded != (ksema.JWK{Kty: "RSA", Kid: want, N: n, E: "AQAB"}) {
+		t.Fatalf("unexpected JWK %+v", encoded)
+	}
+}
+
+// Example of RFC 8037 appendix A.3
+func TestThumbprintEd25519(t *testing.T) {
+	const (
+		x    = "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"
+		want = "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"
+	)
+
+	encoded, err := ksema.PublicKeyJWK(ed25519.PublicKey(decodeBase64URL(t, x)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *encoded != (ksema.JWK{Kty: "OKP", Kid: want, Crv: "Ed25519", X: x}) {
+		t.Fatalf("unexpected JWK %+v", encoded)
+	}
+}
+
+func TestPublicKeyJWKEC(t *testing.T) {
+	for _, tt := range []struct {
+		curve elliptic.Curve
+		crv   string
+		size  int
+	}{
+		{elliptic.P256(), "P-256", 32},
+		{elliptic.P384(), "P-384", 48},
+		{elliptic.P521(), "P-521", 66},
+	} {
+		priv, err := ecdsa.GenerateKey(tt.curve, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jwk, err := ksema.PublicKeyJWK(&priv.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if jwk.Kty != "EC" || jwk.Crv != tt.crv {
+			t.Fatalf("got kty %s and crv %s, want EC and %s", jwk.Kty, jwk.Crv, tt.crv)
+		}
+
+		// The coordinates are padded to the size of the curve
+		x, y := decodeBase64URL(t, jwk.X), decodeBase64URL(t, jwk.Y)
+		if len(x) != tt.size || len(y) != tt.size {
+			t.Fatalf("%s: coordinates of %d and %d bytes, want %d", tt.crv, len(x), len(y), tt.size)
+		}
+		if new(big.Int).SetBytes(x).Cmp(priv.X) != 0 || new(big.Int).SetBytes(y).Cmp(priv.Y) != 0 {
+			t.Fatalf("%s: coordinates do not match the key", tt.crv)
+		}
+	}
+
+	priv, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ksema.PublicKeyJWK(&priv.PublicKey); err == nil {
+		t.Fatal("P-224 key accepted")
+	}
+}
+
+func TestPublicKey(t *testing.T) {
+	srv := newTestServer(t)
+	k := newTestClient(t, srv, ksematest.UserBrigade)
+	genKey(t, k, "PUB01", "PRIV01")
+
+	pub, err := k.PublicKey("PUB01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pub.(*ecdsa.PublicKey); !ok {
+		t.Fatalf("got public key %T, want ECDSA", pub)
+	}
+	// Same key with the private label
+	priv, err := k.PublicKey("PRIV01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub.(*ecdsa.PublicKey).Equal(priv) {
+		t.Fatal("private label return another public key")
+	}
+
+	pemKey, err := ksema.MarshalPublicKeyPEM(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(pemKey)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		t.Fatal("invalid PEM block")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub.(*ecdsa.PublicKey).Equal(parsed) {
+		t.Fatal("PEM does not contain the public key")
+	}
+
+	encoded, err := ksema.MarshalPublicKeyJWK(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jwk ksema.JWK
+	if err := json.Unmarshal(encoded, &jwk); err != nil {
+		t.Fatal(err)
+	}
+	if kid, err := jwk.Thumbprint(); err != nil || kid != jwk.Kid {
+		t.Fatalf("kid %s does not match the thumbprint %s (%v)", jwk.Kid, kid, err)
+	}
+}
@@ -0,0 +1,453 @@
+package ksema
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Interval of the background save of the usage counters
+const USAGE_SAVE_INTERVAL = time.Second
+
+// RateLimit configure a token bucket
+type RateLimit struct {
+	// Number of requests allowed per second, must be positive
+	Rate float64
+	// Maximum number of requests allowed at once
+	// Default is 1
+	Burst int
+}
+
+// Quota configure the usage warning of a key label
+type Quota struct {
+	// Usage limit of the label on the server
+	Limit uint64
+	// Usage which trigger the warning callback
+	Threshold uint64
+}
+
+// QuotaWarning is passed to the warning callback when the usage of a label reach its threshold
+type QuotaWarning struct {
+	Label     string
+	Used      uint64
+	Threshold uint64
+	Limit     uint64
+}
+
+// UsageStore persist the usage counter of every key label
+type UsageStore interface {
+	Load() (map[string]uint64, error)
+	Save(usage map[string]uint64) error
+}
+
+// WithRateLimit limit the rate of every request
+func WithRateLimit(limit RateLimit) Option {
+	return func(c *config) {
+		c.rateLimit = &limit
+	}
+}
+
+// WithOperationRateLimit limit the rate of requests of the operation, e.g. FunctionEncrypt
+func WithOperationRateLimit(op string, limit RateLimit) Option {
+	return func(c *config) {
+		if c.operationLimits == nil {
+			c.operationLimits = make(map[string]RateLimit)
+		}
+		c.operationLimits[op] = limit
+	}
+}
+
+// WithLabelRateLimit limit the rate of requests using the key label
+func WithLabelRateLimit(label string, limit RateLimit) Option {
+	return func(c *config) {
+		if c.labelLimits == nil {
+			c.labelLimits = make(map[string]RateLimit)
+		}
+		c.labelLimits[label] = limit
+	}
+}
+
+// WithQuota set the quota of the key label
+// User object use empty label
+func WithQuota(label string, quota Quota) Option {
+	return func(c *config) {
+		if c.quotas == nil {
+			c.quotas = make(map[string]Quota)
+		}
+		c.quotas[label] = quota
+	}
+}
+
+// WithQuotaWarning set the callback called once per label when its usage reach the threshold
+func WithQuotaWarning(callback func(QuotaWarning)) Option {
+	return func(c *config) {
+		c.quotaWarning = callback
+	}
+}
+
+// WithUsageStore persist the usage counters
+// The counters are loaded at creation and saved periodically and on Close
+func WithUsageStore(store UsageStore) Option {
+	return func(c *config) {
+		c.usageStore = store
+	}
+}
+
+// Token bucket refilled at a constant rate
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	burst := float64(max(limit.Burst, 1))
+	return &tokenBucket{
+		rate:   limit.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// Take a token, return how long to wait before it is available
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Give back a token which was not used
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.burst, b.tokens+1)
+}
+
+// Wait until a token is available or the context is done
+func (b *tokenBucket) wait(ctx context.Context) error {
+	delay := b.reserve()
+	if delay == 0 {
+		return nil
+	}
+	if !sleepContext(ctx, delay) {
+		b.cancel()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("rate limit wait of %s exceed the context deadline: %w", delay, context.DeadlineExceeded)
+	}
+	return nil
+}
+
+// Rate limiter and usage counter of the requests
+type limiter struct {
+	global     *tokenBucket
+	operations map[string]*tokenBucket
+	labels     map[string]*tokenBucket
+
+	quotas  map[string]Quota
+	warning func(QuotaWarning)
+	store   UsageStore
+
+	mu    sync.Mutex
+	usage map[string]uint64
+	dirty bool
+	// Labels already warned, the warning is called once per label
+	warned map[string]bool
+}
+
+// Return the limiter of the config, nil if nothing is configured
+func newLimiter(cfg *config) (*limiter, error) {
+	if cfg.rateLimit == nil && cfg.operationLimits == nil && cfg.labelLimits == nil && cfg.quotas == nil && cfg.usageStore == nil {
+		return nil, nil
+	}
+
+	l := &limiter{
+		operations: make(map[string]*tokenBucket),
+		labels:     make(map[string]*tokenBucket),
+		quotas:     cfg.quotas,
+		warning:    cfg.quotaWarning,
+		store:      cfg.usageStore,
+		usage:      make(map[string]uint64),
+		warned:     make(map[string]bool),
+	}
+	if cfg.rateLimit != nil {
+		if cfg.rateLimit.Rate <= 0 {
+			return nil, fmt.Errorf("invalid rate limit: rate %v must be positive", cfg.rateLimit.Rate)
+		}
+		l.global = newTokenBucket(*cfg.rateLimit)
+	}
+	for op, limit := range cfg.operationLimits {
+		if limit.Rate <= 0 {
+			return nil, fmt.Errorf("invalid rate limit of operation %s: rate %v must be positive", op, limit.Rate)
+		}
+		l.operations[op] = newTokenBucket(limit)
+	}
+	for label, limit := range cfg.labelLimits {
+		if limit.Rate <= 0 {
+			return nil, fmt.Errorf("invalid rate limit of label %s: rate %v must be positive", label, limit.Rate)
+		}
+		l.labels[label] = newTokenBucket(limit)
+	}
+
+	if l.store != nil {
+		usage, err := l.store.Load()
+		if err != nil {
+			return nil, fmt.Errorf("loading usage: %w", err)
+		}
+		for label, used := range usage {
+			l.usage[label] = used
+		}
+	}
+
+	return l, nil
+}
+
+// Wait for a token of every bucket of the request
+// It is called before every request sent to an endpoint, so the retries and failovers are limited too
+// The tokens already taken are given back if a wait failed
+func (l *limiter) wait(ctx context.Context, req ServiceRequest) error {
+	var taken []*tokenBucket
+	for _, bucket := range []*tokenBucket{l.global, l.operations[req.Operation], l.labels[req.Label]} {
+		if bucket == nil {
+			continue
+		}
+		if err := bucket.wait(ctx); err != nil {
+			for _, b := range taken {
+				b.cancel()
+			}
+			return err
+		}
+		taken = append(taken, bucket)
+	}
+	return nil
+}
+
+// Interceptor counting the usage
+func (l *limiter) intercept(ctx context.Context, req *ServiceRequest, invoke Invoker) (*ServiceResponse, error) {
+	res, err := invoke(ctx, req)
+	if err == nil && countUsage(req.Operation) {
+		l.add(req.Label)
+	} else if errors.Is(err, ErrMaxUsage) {
+		l.exhausted(req.Label)
+	}
+
+	return res, err
+}
+
+// Operations counted in the usage of a key
+var usageOperations = []string{
+	FunctionEncrypt,
+	FunctionDecrypt,
+	FunctionSign,
+	FunctionVerify,
+}
+
+func countUsage(op string) bool {
+	return slices.Contains(usageOperations, op)
+}
+
+func (l *limiter) add(label string) {
+	quota, exists := l.quotas[label]
+
+	l.mu.Lock()
+	used := l.usage[label] + 1
+	l.usage[label] = used
+	l.dirty = true
+	// The counter loaded from the store may already be past the threshold
+	warn := exists && quota.Threshold > 0 && used >= quota.Threshold && !l.warned[label]
+	if warn {
+		l.warned[label] = true
+	}
+	l.mu.Unlock()
+
+	if warn {
+		l.warn(label, used, quota)
+	}
+}
+
+// Server returned MAXUSAGE, the limit is reached whatever the counter is
+func (l *limiter) exhausted(label string) {
+	quota, exists := l.quotas[label]
+	if !exists || quota.Limit == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	l.usage[label] = max(l.usage[label], quota.Limit)
+	l.dirty = true
+	warn := quota.Threshold > 0 && !l.warned[label]
+	if warn {
+		l.warned[label] = true
+	}
+	l.mu.Unlock()
+
+	if warn {
+		l.warn(label, quota.Limit, quota)
+	}
+}
+
+func (l *limiter) warn(label string, used uint64, quota Quota) {
+	if l.warning == nil {
+		return
+	}
+	l.warning(QuotaWarning{
+		Label:     label,
+		Used:      used,
+		Threshold: quota.Threshold,
+		Limit:     quota.Limit,
+	})
+}
+
+func (l *limiter) snapshot() map[string]uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	usage := make(map[string]uint64, len(l.usage))
+	for label, used := range l.usage {
+		usage[label] = used
+	}
+	return usage
+}
+
+// Save the usage counters if they changed since the last save
+func (l *limiter) save() error {
+	if l.store == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	dirty := l.dirty
+	l.dirty = false
+	l.mu.Unlock()
+	if !dirty {
+		return nil
+	}
+
+	if err := l.store.Save(l.snapshot()); err != nil {
+		// Retry on the next save
+		l.mu.Lock()
+		l.dirty = true
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Save the usage counters periodically until stopped
+func (k *Ksema) flushUsage() {
+	defer k.wg.Done()
+
+	ticker := time.NewTicker(USAGE_SAVE_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-k.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if err := k.limiter.save(); err != nil {
+			k.logger.Warn("ksema saving usage failed", "error", err.Error())
+		}
+	}
+}
+
+// Usage return the number of requests made with each key label
+// User object use empty label
+func (k *Ksema) Usage() map[string]uint64 {
+	if k.limiter == nil {
+		return map[string]uint64{}
+	}
+	return k.limiter.snapshot()
+}
+
+// SaveUsage save the usage counters to the usage store
+func (k *Ksema) SaveUsage() error {
+	if k.limiter == nil {
+		return nil
+	}
+	return k.limiter.save()
+}
+
+// FileUsageStore persist the usage counters in a JSON file
+type FileUsageStore struct {
+	Path string
+}
+
+// NewFileUsageStore return a usage store writing to the file
+func NewFileUsageStore(path string) *FileUsageStore {
+	return &FileUsageStore{Path: path}
+}
+
+// Load read the counters, return empty counters if the file does not exist
+func (s *FileUsageStore) Load() (map[string]uint64, error) {
+	usage := make(map[string]uint64)
+
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return usage, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// Save write the counters atomically with mode 0600
+func (s *FileUsageStore) Save(usage map[string]uint64) error {
+	data, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.Path, data)
+}
+
+// Write the file through a temporary file with mode 0600 then rename it
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
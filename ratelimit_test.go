@@ -0,0 +1,221 @@
+package ksema_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/suhailiealx/ksema-sdk-go"
+	"github.com/suhailiealx/ksema-sdk-go/ksematest"
+)
+
+// Usage store in memory, failing while err is set
+type memoryUsageStore struct {
+	mu    sync.Mutex
+	usage map[string]uint64
+	saves int
+	err   error
+}
+
+func (s *memoryUsageStore) Load() (map[string]uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usage, nil
+}
+
+func (s *memoryUsageStore) Save(usage map[string]uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.usage = usage
+	s.saves++
+	return nil
+}
+
+func (s *memoryUsageStore) saved() (uint64, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usage["AES01"], s.saves
+}
+
+// Buffer of the logger, safe for the background flush
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestQuotaWarningLoadedUsage(t *testing.T) {
+	srv := newTestServer(t)
+
+	// The stored counter is already past the threshold
+	store := &memoryUsageStore{usage: map[string]uint64{"AES01": 95}}
+	var warnings []ksema.QuotaWarning
+	k := newTestClient(t, srv, ksematest.UserBrigade,
+		ksema.WithQuota("AES01", ksema.Quota{Limit: 1000, Threshold: 90}),
+		ksema.WithQuotaWarning(func(w ksema.QuotaWarning) { warnings = append(warnings, w) }),
+		ksema.WithUsageStore(store),
+	)
+
+	genKey(t, k, "AES01", "")
+	for range 3 {
+		if _, err := k.Encrypt([]byte("data"), "AES01"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(warnings) != 1 {
+		t.Fatalf("got %d warnings, want 1", len(warnings))
+	}
+	if warnings[0].Used != 96 {
+		t.Fatalf("warned at %d, want 96", warnings[0].Used)
+	}
+}
+
+func TestUsageFlush(t *testing.T) {
+	srv := newTestServer(t)
+
+	store := &memoryUsageStore{err: errors.New("disk full")}
+	var logs syncBuffer
+	k, err := srv.NewKsema(ksematest.UserBrigade,
+		ksema.WithQuota("AES01", ksema.Quota{Limit: 1000}),
+		ksema.WithUsageStore(store),
+		ksema.WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close(context.Background())
+
+	genKey(t, k, "AES01", "")
+	if _, err := k.Encrypt([]byte("data"), "AES01"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The failed save is logged and retried
+	eventually(t, 5*time.Second, func() bool {
+		return strings.Contains(logs.String(), "disk full")
+	}, "failed save not logged")
+
+	store.mu.Lock()
+	store.err = nil
+	store.mu.Unlock()
+
+	eventually(t, 5*time.Second, func() bool {
+		used, _ := store.saved()
+		return used == 1
+	}, "usage not saved in background")
+
+	// Nothing changed, nothing to save
+	_, saves := store.saved()
+	time.Sleep(2 * ksema.USAGE_SAVE_INTERVAL)
+	if _, after := store.saved(); after != saves {
+		t.Fatalf("saved %d times without change", after-saves)
+	}
+}
+
+func TestRateLimitInvalid(t *testing.T) {
+	srv := newTestServer(t)
+
+	for name, opt := range map[string]ksema.Option{
+		"global":    ksema.WithRateLimit(ksema.RateLimit{Burst: 10}),
+		"operation": ksema.WithOperationRateLimit(ksema.FunctionEncrypt, ksema.RateLimit{Rate: -1}),
+		"label":     ksema.WithLabelRateLimit("AES01", ksema.RateLimit{Burst: 10}),
+	} {
+		if _, err := srv.NewKsema(ksematest.UserBrigade, opt); err == nil {
+			t.Fatalf("%s rate limit without rate accepted", name)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	srv := newTestServer(t)
+	k := newTestClient(t, srv, ksematest.UserBrigade, ksema.WithRateLimit(ksema.RateLimit{Rate: 20, Burst: 2}))
+
+	// The burst is free, the next two requests wait 50ms each
+	start := time.Now()
+	for range 4 {
+		if err := k.Ping(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("4 requests took %v, want at least 100ms", elapsed)
+	}
+}
+
+func TestRateLimitRefund(t *testing.T) {
+	srv := newTestServer(t)
+	user := srv.NewUser(ksematest.UserBrigade)
+
+	// Generate the keys without limit
+	setup, err := srv.NewClient(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genKey(t, setup, "AES01", "")
+	genKey(t, setup, "AES02", "")
+	setup.Close(context.Background())
+
+	k, err := srv.NewClient(user,
+		ksema.WithRateLimit(ksema.RateLimit{Rate: 0.5, Burst: 2}),
+		ksema.WithLabelRateLimit("AES02", ksema.RateLimit{Rate: 0.5, Burst: 1}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close(context.Background())
+
+	if _, err := k.Encrypt([]byte("data"), "AES02"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The label bucket is empty, the token of the global bucket is given back
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := k.EncryptContext(ctx, []byte("data"), "AES02"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want DeadlineExceeded", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := k.EncryptContext(ctx, []byte("data"), "AES01"); err != nil {
+		t.Fatalf("global token not given back: %v", err)
+	}
+}
+
+func TestRateLimitRetry(t *testing.T) {
+	k, transport := newRetryKsema(t, ksema.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+		ksema.WithRateLimit(ksema.RateLimit{Rate: 10, Burst: 1}))
+
+	// Every attempt take a token, the two retries wait 100ms each
+	transport.fail(ksema.FunctionPing, 2)
+	start := time.Now()
+	if err := k.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if n := transport.count(ksema.FunctionPing); n != 3 {
+		t.Fatalf("got %d attempts, want 3", n)
+	}
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Fatalf("3 attempts took %v, want at least 200ms", elapsed)
+	}
+}
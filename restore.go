@@ -0,0 +1,137 @@
+package ksema
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// LabelConflictError is returned by restore when a key with the same label exists on the server
+// It wraps the error of the server, so errors.Is(err, ErrKeyExisted) is true
+type LabelConflictError struct {
+	// Label of the restored key, empty if it is not known from the backup header
+	Label string
+	Err   error
+}
+
+func (e *LabelConflictError) Error() string {
+	return fmt.Sprintf("key label %q already exists: %v", e.Label, e.Err)
+}
+
+func (e *LabelConflictError) Unwrap() error {
+	return e.Err
+}
+
+// OverwriteRollbackError is returned by restore with WithOverwrite when the backup can not be restored
+// and the existing key can not be restored back either
+// The existing key is deleted from the server, Existing is its only copy and must be restored by the caller
+type OverwriteRollbackError struct {
+	Label string
+	// Backup of the deleted existing key
+	Existing *BackupBlob
+	// Error of the restore of the backup
+	Err error
+	// Error of the restore of the existing key
+	RollbackErr error
+}
+
+func (e *OverwriteRollbackError) Error() string {
+	return fmt.Sprintf("overwriting key label %q: %v, restoring existing key back: %v", e.Label, e.Err, e.RollbackErr)
+}
+
+func (e *OverwriteRollbackError) Unwrap() []error {
+	return []error{e.Err, e.RollbackErr}
+}
+
+// ErrOverwriteNotSupported is returned when WithOverwrite is used with the keypair of user object
+var ErrOverwriteNotSupported = errors.New("overwrite is not supported for the keypair of user object")
+
+// WithOverwrite replace the existing key with the same label by the backup
+//
+// The existing key is backed up before it is deleted,
+// and restored back if the backup can not be restored, otherwise *OverwriteRollbackError is returned
+func WithOverwrite() BackupOption {
+	return func(o *backupOptions) {
+		o.overwrite = true
+	}
+}
+
+// Perform restore of the backup read from r
+// The backup is validated before the request, see ReadBackup
+func (k *Ksema) RestoreFrom(ctx context.Context, r io.Reader, opts ...BackupOption) error {
+	backup, err := ReadBackup(r, opts...)
+	if err != nil {
+		return err
+	}
+	return k.RestoreBlob(ctx, backup, opts...)
+}
+
+// Perform restore of the exported keys of the backup
+//
+// WithOverwrite need the label of the backup header, so it only support the backup with one exported key
+// and return ErrOverwriteNotSupported for the keypair of user object
+func (k *Ksema) RestoreBlob(ctx context.Context, backup *BackupBlob, opts ...BackupOption) error {
+	if err := checkBackupBlobs(backup.Blobs, backup.UserType); err != nil {
+		return err
+	}
+	o := newBackupOptions(opts)
+	if o.overwrite && len(backup.Blobs) > 1 {
+		return ErrOverwriteNotSupported
+	}
+
+	for i, blob := range backup.Blobs {
+		var label string
+		if i == 0 {
+			label = backup.Label
+		}
+		if err := k.restoreBlob(ctx, blob, label, o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore an exported key, overwriting the existing key if its label already exists and WithOverwrite is used
+func (k *Ksema) restoreBlob(ctx context.Context, blob []byte, label string, o *backupOptions) error {
+	err := operationRestore(ctx, k.request, blob)
+	if !errors.Is(err, ErrKeyExisted) {
+		return err
+	}
+	if !o.overwrite || label == "" {
+		return &LabelConflictError{Label: label, Err: err}
+	}
+	return k.overwriteKey(ctx, blob, label)
+}
+
+// Replace the existing key of the label by the exported key
+// The existing key is backed up first, so it is restored back if the exported key can not be restored
+func (k *Ksema) overwriteKey(ctx context.Context, blob []byte, label string) error {
+	existing, err := k.BackupKey(ctx, label)
+	if err != nil {
+		return fmt.Errorf("backing up existing key: %w", err)
+	}
+	if len(existing.Blobs) != 1 {
+		return ErrOverwriteNotSupported
+	}
+
+	if err := k.DeleteContext(ctx, label); err != nil {
+		return fmt.Errorf("deleting existing key: %w", err)
+	}
+
+	err = operationRestore(ctx, k.request, blob)
+	if err == nil {
+		return nil
+	}
+
+	// The existing key must be restored back even if the context is canceled
+	if rbErr := operationRestore(context.WithoutCancel(ctx), k.request, existing.Blobs[0]); rbErr != nil {
+		return &OverwriteRollbackError{
+			Label:       label,
+			Existing:    existing,
+			Err:         err,
+			RollbackErr: rbErr,
+		}
+	}
+	return err
+}
@@ -0,0 +1,148 @@
+package ksema_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/suhailiealx/ksema-sdk-go"
+	"github.com/suhailiealx/ksema-sdk-go/ksematest"
+)
+
+// Generate AES01 on a new client and back it up
+// Return the client, the backup and a ciphertext of the backed up key
+func newRestoreKsema(t *testing.T, opts ...ksema.Option) (*ksema.Ksema, *ksema.BackupBlob, []byte) {
+	t.Helper()
+	srv := newTestServer(t)
+
+	k := newTestClient(t, srv, ksematest.UserBrigade, opts...)
+
+	genKey(t, k, "AES01", "")
+	cipherText, err := k.Encrypt([]byte("backed up"), "AES01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup, err := k.BackupKey(context.Background(), "AES01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k, backup, cipherText
+}
+
+// Replace AES01 by a new key, return a ciphertext of the new key
+func replaceKey(t *testing.T, k *ksema.Ksema) []byte {
+	t.Helper()
+	if err := k.Delete("AES01"); err != nil {
+		t.Fatal(err)
+	}
+	genKey(t, k, "AES01", "")
+	cipherText, err := k.Encrypt([]byte("existing"), "AES01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cipherText
+}
+
+func checkDecrypt(t *testing.T, k *ksema.Ksema, cipherText []byte, want string) {
+	t.Helper()
+	plainText, err := k.Decrypt(cipherText, "AES01")
+	if err != nil {
+		t.Fatalf("decrypt %q: %v", want, err)
+	}
+	if string(plainText) != want {
+		t.Fatalf("got %q, want %q", plainText, want)
+	}
+}
+
+func TestRestoreConflict(t *testing.T) {
+	k, backup, _ := newRestoreKsema(t)
+	existing := replaceKey(t, k)
+
+	err := k.RestoreBlob(context.Background(), backup)
+	var conflict *ksema.LabelConflictError
+	if !errors.As(err, &conflict) || conflict.Label != "AES01" || !errors.Is(err, ksema.ErrKeyExisted) {
+		t.Fatalf("got %v, want label conflict of AES01", err)
+	}
+	checkDecrypt(t, k, existing, "existing")
+}
+
+func TestRestoreOverwrite(t *testing.T) {
+	k, backup, backedUp := newRestoreKsema(t)
+	replaceKey(t, k)
+
+	if err := k.RestoreBlob(context.Background(), backup, ksema.WithOverwrite()); err != nil {
+		t.Fatal(err)
+	}
+	checkDecrypt(t, k, backedUp, "backed up")
+}
+
+func TestRestoreOverwriteRollback(t *testing.T) {
+	// Fail the restore following the deletion of the existing key
+	var restores atomic.Int32
+	failRestore := func(ctx context.Context, req *ksema.ServiceRequest, invoke ksema.Invoker) (*ksema.ServiceResponse, error) {
+		if req.Operation == ksema.FunctionRestore && restores.Add(1) == 2 {
+			return nil, &ksema.Error{Op: req.Operation, RetCode: ksema.FAILED, ErrorMsg: "injected"}
+		}
+		return invoke(ctx, req)
+	}
+
+	k, backup, _ := newRestoreKsema(t, ksema.WithInterceptors(failRestore))
+	existing := replaceKey(t, k)
+
+	err := k.RestoreBlob(context.Background(), backup, ksema.WithOverwrite())
+	if !errors.Is(err, ksema.ErrFailed) {
+		t.Fatalf("got %v, want ErrFailed", err)
+	}
+	// The existing key is restored back
+	checkDecrypt(t, k, existing, "existing")
+}
+
+func TestRestoreOverwriteRollbackFailed(t *testing.T) {
+	// Fail the restore of the backup and the restore of the existing key back
+	var restores atomic.Int32
+	failRestore := func(ctx context.Context, req *ksema.ServiceRequest, invoke ksema.Invoker) (*ksema.ServiceResponse, error) {
+		if req.Operation != ksema.FunctionRestore {
+			return invoke(ctx, req)
+		}
+		if n := restores.Add(1); n == 2 || n == 3 {
+			return nil, &ksema.Error{Op: req.Operation, RetCode: ksema.FAILED, ErrorMsg: "injected"}
+		}
+		return invoke(ctx, req)
+	}
+
+	k, backup, _ := newRestoreKsema(t, ksema.WithInterceptors(failRestore))
+	existing := replaceKey(t, k)
+
+	err := k.RestoreBlob(context.Background(), backup, ksema.WithOverwrite())
+	var rollback *ksema.OverwriteRollbackError
+	if !errors.As(err, &rollback) || rollback.Label != "AES01" || rollback.Existing == nil {
+		t.Fatalf("got %v, want OverwriteRollbackError of AES01", err)
+	}
+	if !errors.Is(err, ksema.ErrFailed) {
+		t.Fatalf("got %v, want ErrFailed", err)
+	}
+	if _, err := k.Encrypt([]byte("data"), "AES01"); err == nil {
+		t.Fatal("existing key not deleted")
+	}
+
+	// The caller restore the existing key with the returned backup
+	if err := k.RestoreBlob(context.Background(), rollback.Existing); err != nil {
+		t.Fatal(err)
+	}
+	checkDecrypt(t, k, existing, "existing")
+}
+
+func TestRestoreOverwriteKeyPair(t *testing.T) {
+	srv := newTestServer(t)
+	k := newTestClient(t, srv, ksematest.UserContra)
+
+	backup, err := ksema.ReadLegacyBackupPair(strings.NewReader("KSEMA;PAIR;pub\npublic"), strings.NewReader("KSEMA;PAIR;pub\nprivate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.RestoreBlob(context.Background(), backup, ksema.WithOverwrite()); !errors.Is(err, ksema.ErrOverwriteNotSupported) {
+		t.Fatalf("got %v, want ErrOverwriteNotSupported", err)
+	}
+}
@@ -0,0 +1,126 @@
+package ksema
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"time"
+)
+
+const (
+	DEFAULT_RETRY_BASE_DELAY = 100 * time.Millisecond
+	DEFAULT_RETRY_MAX_DELAY  = 5 * time.Second
+)
+
+// Operations which are safe to be sent more than once
+var idempotentOperations = []string{
+	FunctionPing,
+	FunctionEncrypt,
+	FunctionSign,
+	FunctionVerify,
+	FunctionRNG,
+	FunctionGetPubKey,
+}
+
+// RetryPolicy define how failed requests are retried
+//
+// Only idempotent operations (PING, ENCRYPT, SIGN, VERIFY, RNG and GETPUBKEY) are retried,
+// other operations must be listed in UnsafeOperations to be retried
+type RetryPolicy struct {
+	// Maximum number of attempts including the first one, retry is disabled if less than 2
+	MaxAttempts int
+	// Delay before the first retry, doubled on each retry
+	// Default is 100ms
+	BaseDelay time.Duration
+	// Maximum delay between retries
+	// Default is 5s
+	MaxDelay time.Duration
+	// Classify which errors can be retried
+	// Default is IsRetryable
+	Retryable func(error) bool
+	// Non idempotent operations which are allowed to be retried, e.g. FunctionGenKeySym
+	UnsafeOperations []string
+}
+
+// WithRetryPolicy enable retry of failed requests with the given policy
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *config) {
+		c.retry = policy
+	}
+}
+
+// IsRetryable is the default classifier of RetryPolicy
+// Return true for network errors and 5xx responses
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var kErr *Error
+	if errors.As(err, &kErr) {
+		return kErr.HTTPStatus >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Return the number of attempts allowed for the operation
+func (p *RetryPolicy) attempts(op string) int {
+	if p.MaxAttempts < 2 {
+		return 1
+	}
+	if !slices.Contains(idempotentOperations, op) && !slices.Contains(p.UnsafeOperations, op) {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// Return the delay before the next attempt with jitter
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	base := p.BaseDelay
+	if base <= 0 {
+		base = DEFAULT_RETRY_BASE_DELAY
+	}
+	maxDelay := p.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = DEFAULT_RETRY_MAX_DELAY
+	}
+
+	delay := maxDelay
+	if attempt < 32 && base<<attempt > 0 && base<<attempt < maxDelay {
+		delay = base << attempt
+	}
+
+	// Equal jitter, keep at least half of the delay
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// Wait for the delay
+// Return false if the context is done before, or its deadline is earlier than the delay
+func sleepContext(ctx context.Context, delay time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		return false
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,164 @@
+package ksema_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/suhailiealx/ksema-sdk-go"
+	"github.com/suhailiealx/ksema-sdk-go/ksematest"
+)
+
+// Transport answering 503 to the requests of the operations while they have failures left
+// It count the requests of every operation
+type unavailableTransport struct {
+	base http.RoundTripper
+
+	mu       sync.Mutex
+	failures map[string]int
+	requests map[string]int
+}
+
+func (u *unavailableTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !strings.HasSuffix(req.URL.Path, "/request") {
+		return u.base.RoundTrip(req)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	var payload ksema.ServiceRequest
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	u.mu.Lock()
+	u.requests[payload.Operation]++
+	fail := u.failures[payload.Operation] > 0
+	if fail {
+		u.failures[payload.Operation]--
+	}
+	u.mu.Unlock()
+
+	if fail {
+		return &http.Response{
+			StatusCode: http.StatusServiceUnavailable,
+			Status:     "503 Service Unavailable",
+			Body:       io.NopCloser(strings.NewReader("")),
+			Header:     make(http.Header),
+			Request:    req,
+		}, nil
+	}
+	return u.base.RoundTrip(req)
+}
+
+func (u *unavailableTransport) fail(op string, times int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.failures[op] = times
+	clear(u.requests)
+}
+
+func (u *unavailableTransport) count(op string) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.requests[op]
+}
+
+func newRetryKsema(t *testing.T, policy ksema.RetryPolicy, opts ...ksema.Option) (*ksema.Ksema, *unavailableTransport) {
+	t.Helper()
+	srv := newTestServer(t)
+
+	transport := &unavailableTransport{
+		base:     &http.Transport{TLSClientConfig: &tls.Config{RootCAs: srv.CertPool()}},
+		failures: make(map[string]int),
+		requests: make(map[string]int),
+	}
+	opts = append([]ksema.Option{
+		ksema.WithHTTPClient(&http.Client{Transport: transport}),
+		ksema.WithRetryPolicy(policy),
+	}, opts...)
+	k := newTestClient(t, srv, ksematest.UserBrigade, opts...)
+	return k, transport
+}
+
+func TestRetryServerError(t *testing.T) {
+	k, transport := newRetryKsema(t, ksema.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+	genKey(t, k, "AES01", "")
+
+	// Two 503 then success
+	transport.fail(ksema.FunctionEncrypt, 2)
+	if _, err := k.Encrypt([]byte("data"), "AES01"); err != nil {
+		t.Fatalf("encrypt after two 503: %v", err)
+	}
+	if n := transport.count(ksema.FunctionEncrypt); n != 3 {
+		t.Fatalf("got %d attempts, want 3", n)
+	}
+
+	// The attempts are exhausted
+	transport.fail(ksema.FunctionEncrypt, 3)
+	_, err := k.Encrypt([]byte("data"), "AES01")
+	var kErr *ksema.Error
+	if !errors.As(err, &kErr) || kErr.HTTPStatus != http.StatusServiceUnavailable {
+		t.Fatalf("got %v, want 503", err)
+	}
+	if n := transport.count(ksema.FunctionEncrypt); n != 3 {
+		t.Fatalf("got %d attempts, want 3", n)
+	}
+}
+
+func TestRetryUnsafeOperations(t *testing.T) {
+	k, transport := newRetryKsema(t, ksema.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+
+	// GENKEYSYM is not retried by default
+	transport.fail(ksema.FunctionGenKeySym, 1)
+	if err := k.GenKey("AES01", ""); err == nil {
+		t.Fatal("GENKEYSYM retried without opt in")
+	}
+	if n := transport.count(ksema.FunctionGenKeySym); n != 1 {
+		t.Fatalf("got %d attempts, want 1", n)
+	}
+
+	k, transport = newRetryKsema(t, ksema.RetryPolicy{
+		MaxAttempts:      3,
+		BaseDelay:        time.Millisecond,
+		UnsafeOperations: []string{ksema.FunctionGenKeySym},
+	})
+	transport.fail(ksema.FunctionGenKeySym, 1)
+	if err := k.GenKey("AES01", ""); err != nil {
+		t.Fatalf("GENKEYSYM listed in UnsafeOperations: %v", err)
+	}
+	if n := transport.count(ksema.FunctionGenKeySym); n != 2 {
+		t.Fatalf("got %d attempts, want 2", n)
+	}
+}
+
+func TestRetryDeadline(t *testing.T) {
+	k, transport := newRetryKsema(t, ksema.RetryPolicy{MaxAttempts: 10, BaseDelay: time.Second})
+
+	// The first backoff is longer than the deadline
+	transport.fail(ksema.FunctionPing, 10)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := k.PingContext(ctx); err == nil {
+		t.Fatal("ping succeeded with failing server")
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("retry waited %v past the deadline", elapsed)
+	}
+	if n := transport.count(ksema.FunctionPing); n != 1 {
+		t.Fatalf("got %d attempts, want 1", n)
+	}
+}
@@ -0,0 +1,241 @@
+package ksema
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Timeout of the shared key exchange of re-authentication
+const DEFAULT_REAUTH_TIMEOUT = 30 * time.Second
+
+// ReauthEvent describe a re-authentication triggered by an invalid session
+type ReauthEvent struct {
+	// Operation which received the invalid session
+	Op string
+	// Address of the endpoint
+	Endpoint string
+	// Time taken by the key exchange
+	Duration time.Duration
+	// Error of the key exchange, nil if success
+	Err error
+}
+
+// WithReauthHook set a function called after every re-authentication
+func WithReauthHook(hook func(ReauthEvent)) Option {
+	return func(c *config) {
+		c.reauthHook = hook
+	}
+}
+
+// Re-authentication in progress, shared by concurrent callers
+type authCall struct {
+	done chan struct{}
+	err  error
+}
+
+// Return the user type of the current session
+func (k *Ksema) getUserType() int {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.userType
+}
+
+// Send the request through the interceptors, retrying it according to the retry policy
+func (k *Ksema) request(ctx context.Context, payload ServiceRequest) (*ServiceResponse, error) {
+	if k.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	info := callInfoFromContext(ctx)
+	if info == nil {
+		info = &CallInfo{}
+		ctx = WithCallInfo(ctx, info)
+	}
+
+	var reqInfo RequestInfo
+	if k.instrumentation != nil {
+		reqInfo = RequestInfo{
+			Op:          payload.Operation,
+			Label:       payload.Label,
+			UserType:    k.getUserType(),
+			RequestSize: len(payload.Data),
+		}
+		ctx = k.instrumentation.Start(ctx, reqInfo)
+	}
+
+	start := time.Now()
+	res, err := k.invoker(ctx, &payload)
+	if err == nil && len(k.interceptors) > 0 {
+		// Response may be short-circuited by an interceptor
+		if err = checkResponse(&payload, res, 0); err != nil {
+			res = nil
+		}
+	}
+	duration := time.Since(start)
+
+	k.logRequest(ctx, payload, info, duration, err)
+	if k.instrumentation != nil {
+		k.instrumentation.End(ctx, reqInfo, newResultInfo(info, duration, res, err))
+	}
+
+	return res, err
+}
+
+// Invoker at the end of the interceptor chain
+func (k *Ksema) requestRetry(ctx context.Context, req *ServiceRequest) (*ServiceResponse, error) {
+	payload := *req
+	info := callInfoFromContext(ctx)
+	attempts := k.retry.attempts(payload.Operation)
+
+	for attempt := 0; ; attempt++ {
+		info.Attempts = attempt + 1
+		res, err := k.sendFailover(ctx, payload)
+		if err == nil || attempt+1 >= attempts || !k.retry.retryable(err) {
+			return res, err
+		}
+		if !sleepContext(ctx, k.retry.backoff(attempt)) {
+			return nil, err
+		}
+	}
+}
+
+// Send the request to the selected endpoint
+// Fail over to the next endpoint on connection error
+func (k *Ksema) sendFailover(ctx context.Context, payload ServiceRequest) (*ServiceResponse, error) {
+	var lastErr error
+
+	for _, ep := range k.selectEndpoints() {
+		if info := callInfoFromContext(ctx); info != nil {
+			info.Endpoint = ep.address
+		}
+
+		if err := k.acquireBreaker(ctx, ep); err != nil {
+			lastErr = err
+			continue
+		}
+		if k.limiter != nil {
+			if err := k.limiter.wait(ctx, payload); err != nil {
+				return nil, err
+			}
+		}
+
+		start := time.Now()
+		res, err := k.send(ctx, ep, payload)
+		k.recordBreaker(ep, err)
+		if err == nil {
+			ep.markUp(time.Since(start))
+			k.updateHealth()
+			return res, nil
+		}
+		if !isConnectionError(err) {
+			return nil, err
+		}
+
+		ep.markDown(err, k.coolOff)
+		k.updateHealth()
+		k.logger.WarnContext(ctx, "ksema endpoint marked down", "endpoint", ep.address, "error", err.Error())
+		lastErr = err
+
+		// The request may have reached the server, only resend the safe one
+		if !isDialError(err) && !isIdempotent(payload.Operation) {
+			break
+		}
+	}
+
+	return nil, lastErr
+}
+
+// Send the request with the current session of the endpoint
+// If the session is invalid, it re-authenticate and replay the request once
+func (k *Ksema) send(ctx context.Context, ep *endpoint, payload ServiceRequest) (*ServiceResponse, error) {
+	sessID := ep.sessionID()
+	if sessID == "" {
+		// Key exchange was failed when the endpoint is down
+		if err := k.reauth(ctx, ep, payload.Operation, sessID); err != nil {
+			return nil, err
+		}
+		sessID = ep.sessionID()
+	}
+	payload.SessionID = sessID
+
+	res, err := k.sendRequest(ctx, ep.address, payload)
+	if err == nil || !isSessionInvalid(err) {
+		return res, err
+	}
+
+	if err := k.reauth(ctx, ep, payload.Operation, sessID); err != nil {
+		return nil, err
+	}
+
+	payload.SessionID = ep.sessionID()
+	return k.sendRequest(ctx, ep.address, payload)
+}
+
+// Perform the key exchange again if the session of the endpoint is still the stale one
+//
+// Concurrent callers with the same stale session wait for a single key exchange
+// The key exchange is not canceled with the context of the caller which started it,
+// every caller stop waiting on its own context
+func (k *Ksema) reauth(ctx context.Context, ep *endpoint, op, stale string) error {
+	ep.mu.Lock()
+	if ep.sessID != stale {
+		ep.mu.Unlock()
+		return nil
+	}
+	call := ep.authCall
+	if call == nil {
+		call = &authCall{done: make(chan struct{})}
+		ep.authCall = call
+		go k.runReauth(context.WithoutCancel(ctx), ep, op, call)
+	}
+	ep.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Perform the shared key exchange of reauth and notify the waiting callers
+func (k *Ksema) runReauth(ctx context.Context, ep *endpoint, op string, call *authCall) {
+	timeout := k.client.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_REAUTH_TIMEOUT
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	_, call.err = k.keyExchange(ctx, ep)
+	if call.err != nil {
+		k.logger.WarnContext(ctx, "ksema re-authentication failed", "op", op, "endpoint", ep.address, "duration", time.Since(start), "error", call.err.Error())
+	} else {
+		k.logger.InfoContext(ctx, "ksema re-authenticated", "op", op, "endpoint", ep.address, "duration", time.Since(start))
+	}
+	if k.reauthHook != nil {
+		k.reauthHook(ReauthEvent{
+			Op:       op,
+			Endpoint: ep.address,
+			Duration: time.Since(start),
+			Err:      call.err,
+		})
+	}
+
+	ep.mu.Lock()
+	ep.authCall = nil
+	ep.mu.Unlock()
+	close(call.done)
+}
+
+// Check if the error is caused by an expired or invalid session
+func isSessionInvalid(err error) bool {
+	var kErr *Error
+	if !errors.As(err, &kErr) {
+		return false
+	}
+	return kErr.RetCode == SESSIONINVALID || kErr.HTTPStatus == http.StatusUnauthorized
+}
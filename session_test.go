@@ -0,0 +1,139 @@
+package ksema_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/suhailiealx/ksema-sdk-go"
+	"github.com/suhailiealx/ksema-sdk-go/ksematest"
+)
+
+func TestReauthSingleFlight(t *testing.T) {
+	srv := newTestServer(t)
+
+	var events atomic.Int32
+	k := newTestClient(t, srv, ksematest.UserBrigade, ksema.WithReauthHook(func(e ksema.ReauthEvent) {
+		if e.Err != nil {
+			t.Errorf("re-authentication failed: %v", e.Err)
+		}
+		events.Add(1)
+	}))
+
+	srv.ExpireSessions()
+
+	const calls = 32
+	var wg sync.WaitGroup
+	for range calls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := k.Ping(); err != nil {
+				t.Errorf("ping: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := events.Load(); n != 1 {
+		t.Fatalf("got %d re-authentications, want 1", n)
+	}
+	if n := srv.Sessions(); n != 1 {
+		t.Fatalf("got %d sessions, want 1", n)
+	}
+}
+
+// Block the HTTP request number block after it is armed
+type blockingInstrumentation struct {
+	mu      sync.Mutex
+	armed   bool
+	count   int
+	block   int
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingInstrumentation) Start(ctx context.Context, _ ksema.RequestInfo) context.Context {
+	return ctx
+}
+
+func (b *blockingInstrumentation) Inject(context.Context, http.Header) {
+	b.mu.Lock()
+	blocked := false
+	if b.armed {
+		b.count++
+		blocked = b.count == b.block
+	}
+	b.mu.Unlock()
+
+	if blocked {
+		close(b.started)
+		<-b.release
+	}
+}
+
+func (b *blockingInstrumentation) End(context.Context, ksema.RequestInfo, ksema.ResultInfo) {}
+
+func TestReauthCanceledCaller(t *testing.T) {
+	srv := newTestServer(t)
+
+	// The first request get the invalid session, the second is the key exchange
+	inst := &blockingInstrumentation{
+		block:   2,
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	k := newTestClient(t, srv, ksematest.UserBrigade, ksema.WithInstrumentation(inst))
+
+	srv.ExpireSessions()
+	inst.mu.Lock()
+	inst.armed = true
+	inst.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		first <- k.PingContext(ctx)
+	}()
+	<-inst.started
+
+	// Wait for the key exchange started by the first caller
+	second := make(chan error, 1)
+	go func() {
+		second <- k.PingContext(context.Background())
+	}()
+	for {
+		inst.mu.Lock()
+		sent := inst.count >= 3
+		inst.mu.Unlock()
+		if sent {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case err := <-first:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("canceled caller: got %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		close(inst.release)
+		t.Fatal("canceled caller did not return")
+	}
+
+	close(inst.release)
+	select {
+	case err := <-second:
+		if err != nil {
+			t.Fatalf("waiting caller: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting caller did not return")
+	}
+}
@@ -0,0 +1,113 @@
+package ksema
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrUnsupportedSignature is returned by KeySigner when the key and the signer options are not supported by the server
+var ErrUnsupportedSignature = errors.New("unsupported signature algorithm")
+
+// KeySigner implement crypto.Signer with the private key label of the server
+// It can be used by x509, tls and JOSE libraries
+//
+// Only ECDSA keypair is supported, the digest is sent as is to SIGN and the server must sign it without hashing.
+// Every signature is verified with the public key, ErrUnsupportedSignature is returned if the server signed something else
+type KeySigner struct {
+	k         *Ksema
+	privLabel string
+	public    *ecdsa.PublicKey
+}
+
+var _ crypto.Signer = (*KeySigner)(nil)
+
+// Return a crypto.Signer using the keypair of the server
+// The public key is fetched from the public label
+// Return ErrUnsupportedSignature if the keypair is not ECDSA
+func (k *Ksema) Signer(privLabel, pubLabel string) (*KeySigner, error) {
+	return k.SignerContext(context.Background(), privLabel, pubLabel)
+}
+
+// SignerContext is like Signer but bound to the context
+func (k *Ksema) SignerContext(ctx context.Context, privLabel, pubLabel string) (*KeySigner, error) {
+	if k.getUserType() > USER_OBJECT && (privLabel == "" || pubLabel == "") {
+		return nil, ErrNoKeyLabel
+	}
+
+	public, err := k.PublicKeyContext(ctx, pubLabel)
+	if err != nil {
+		return nil, err
+	}
+	ecPublic, ok := public.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: public key type %T", ErrUnsupportedSignature, public)
+	}
+
+	return &KeySigner{
+		k:         k,
+		privLabel: privLabel,
+		public:    ecPublic,
+	}, nil
+}
+
+// Return the public key of the keypair
+func (s *KeySigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign the digest with the private key of the server
+// The rand argument is ignored, the randomness is provided by the server
+func (s *KeySigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.SignContext(context.Background(), digest, opts)
+}
+
+// SignContext is like Sign but bound to the context
+func (s *KeySigner) SignContext(ctx context.Context, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	data, err := s.signData(digest, opts)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := s.k.SignContext(ctx, data, s.privLabel)
+	if err != nil {
+		return nil, err
+	}
+	if !ecdsa.VerifyASN1(s.public, digest, signature) {
+		return nil, fmt.Errorf("%w: signature of the server does not match the digest", ErrUnsupportedSignature)
+	}
+	return signature, nil
+}
+
+// Return the data to be signed by the server according to the options
+func (s *KeySigner) signData(digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts == nil {
+		opts = crypto.Hash(0)
+	}
+	hash := opts.HashFunc()
+
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		return nil, fmt.Errorf("%w: PSS options with ECDSA key", ErrUnsupportedSignature)
+	}
+	if err := checkDigest(digest, hash); err != nil {
+		return nil, err
+	}
+	return digest, nil
+}
+
+// Check that the length of the digest match the hash
+func checkDigest(digest []byte, hash crypto.Hash) error {
+	if hash == 0 {
+		return nil
+	}
+	if hash > crypto.BLAKE2b_512 {
+		return fmt.Errorf("%w: hash %v", ErrUnsupportedSignature, hash)
+	}
+	if len(digest) != hash.Size() {
+		return fmt.Errorf("digest length %d does not match %v (%d bytes)", len(digest), hash, hash.Size())
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package ksema_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/suhailiealx/ksema-sdk-go"
+	"github.com/suhailiealx/ksema-sdk-go/ksematest"
+)
+
+func newSigner(t *testing.T, opts ...ksema.Option) *ksema.KeySigner {
+	t.Helper()
+	srv := newTestServer(t)
+
+	k := newTestClient(t, srv, ksematest.UserBrigade, opts...)
+	genKey(t, k, "PUB01", "PRIV01")
+
+	signer, err := k.Signer("PRIV01", "PUB01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestKeySigner(t *testing.T) {
+	signer := newSigner(t)
+
+	digest := sha256.Sum256([]byte("message"))
+	signature, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(signer.Public().(*ecdsa.PublicKey), digest[:], signature) {
+		t.Fatal("signature does not verify with the public key")
+	}
+
+	// Self-signed certificate through crypto.Signer
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ksema"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeySignerOptions(t *testing.T) {
+	signer := newSigner(t)
+	digest := sha256.Sum256([]byte("message"))
+
+	if _, err := signer.Sign(rand.Reader, digest[:16], crypto.SHA256); err == nil {
+		t.Fatal("digest of the wrong length accepted")
+	}
+	pss := &rsa.PSSOptions{Hash: crypto.SHA256}
+	if _, err := signer.Sign(rand.Reader, digest[:], pss); !errors.Is(err, ksema.ErrUnsupportedSignature) {
+		t.Fatalf("PSS options: got %v, want ErrUnsupportedSignature", err)
+	}
+}
+
+func TestKeySignerHashingServer(t *testing.T) {
+	// Server hashing the data before signing it
+	hashing := func(ctx context.Context, req *ksema.ServiceRequest, invoke ksema.Invoker) (*ksema.ServiceResponse, error) {
+		if req.Operation == ksema.FunctionSign {
+			digest := sha256.Sum256(req.Data)
+			req.Data = digest[:]
+		}
+		return invoke(ctx, req)
+	}
+	signer := newSigner(t, ksema.WithInterceptors(hashing))
+
+	digest := sha256.Sum256([]byte("message"))
+	if _, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256); !errors.Is(err, ksema.ErrUnsupportedSignature) {
+		t.Fatalf("signature of the hashed digest: got %v, want ErrUnsupportedSignature", err)
+	}
+}
@@ -0,0 +1,318 @@
+package ksema
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Stream format
+//
+// The stream start with the header :
+//
+//	magic      8 bytes  "KSEMASTR"
+//	version    1 byte   STREAM_VERSION
+//	chunk size 4 bytes  maximum plaintext size of a chunk
+//	stream ID  16 bytes random
+//	label      2 bytes length followed by the key label
+//
+// Followed by the frames, each frame is a chunk encrypted by the server :
+//
+//	sequence   8 bytes  starting from 0
+//	flags      1 byte   STREAM_FLAG_FINAL on the last frame
+//	length     4 bytes  length of the encrypted chunk
+//	encrypted  length bytes
+//
+// The encrypted chunk contains the stream ID, sequence and flags followed by the data,
+// so truncation, reordering and frames of another stream are detected on decryption
+// Integers are big endian
+const (
+	STREAM_MAGIC              = "KSEMASTR"
+	STREAM_VERSION            = 1
+	STREAM_FLAG_FINAL         = 0x01
+	DEFAULT_STREAM_CHUNK_SIZE = 32 * 1024
+	MAX_STREAM_CHUNK_SIZE     = 16 * 1024 * 1024
+
+	streamIDLen = 16
+	// Stream ID, sequence and flags prepended to the chunk before encryption
+	streamChunkHeaderLen = streamIDLen + 8 + 1
+	// Maximum size added by the encryption of the server
+	streamCipherOverhead = 1024
+)
+
+var (
+	// ErrStreamTruncated is returned when the stream end before the final frame
+	ErrStreamTruncated = errors.New("ksema stream is truncated")
+	// ErrStreamCorrupted is returned when the stream is malformed, reordered or tampered
+	ErrStreamCorrupted = errors.New("ksema stream is corrupted")
+)
+
+// WithStreamChunkSize set the plaintext size of each chunk of the encrypt writer
+// Default is 32 KiB
+func WithStreamChunkSize(size int) Option {
+	return func(c *config) {
+		c.streamChunkSize = size
+	}
+}
+
+// EncryptWriter encrypt the written data by chunk through the server
+// Close must be called to write the final frame
+type EncryptWriter struct {
+	k        *Ksema
+	ctx      context.Context
+	w        io.Writer
+	label    string
+	streamID []byte
+	buf      []byte
+	seq      uint64
+	err      error
+	closed   bool
+}
+
+// NewEncryptWriter return a writer encrypting the data to w with the key label
+func (k *Ksema) NewEncryptWriter(w io.Writer, label string) (*EncryptWriter, error) {
+	return k.NewEncryptWriterContext(context.Background(), w, label)
+}
+
+// NewEncryptWriterContext is like NewEncryptWriter but every request is bound to the context
+func (k *Ksema) NewEncryptWriterContext(ctx context.Context, w io.Writer, label string) (*EncryptWriter, error) {
+	if k.getUserType() > USER_OBJECT && label == "" {
+		return nil, ErrNoKeyLabel
+	}
+	if len(label) > 0xFFFF {
+		return nil, errors.New("key label is too long")
+	}
+
+	ew := &EncryptWriter{
+		k:        k,
+		ctx:      ctx,
+		w:        w,
+		label:    label,
+		streamID: make([]byte, streamIDLen),
+		buf:      make([]byte, 0, k.streamChunkSize),
+	}
+	if _, err := rand.Read(ew.streamID); err != nil {
+		return nil, err
+	}
+
+	header := []byte(STREAM_MAGIC)
+	header = append(header, STREAM_VERSION)
+	header = binary.BigEndian.AppendUint32(header, uint32(k.streamChunkSize))
+	header = append(header, ew.streamID...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(label)))
+	header = append(header, label...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return ew, nil
+}
+
+// Write buffer the data and encrypt every full chunk
+func (ew *EncryptWriter) Write(p []byte) (int, error) {
+	if ew.err != nil {
+		return 0, ew.err
+	}
+	if ew.closed {
+		return 0, errors.New("write to closed encrypt writer")
+	}
+
+	n := 0
+	for len(p) > 0 {
+		// Keep the last chunk in the buffer, it may be the final one
+		if len(ew.buf) == cap(ew.buf) {
+			if ew.err = ew.writeFrame(0); ew.err != nil {
+				return n, ew.err
+			}
+		}
+		c := min(len(p), cap(ew.buf)-len(ew.buf))
+		ew.buf = append(ew.buf, p[:c]...)
+		p = p[c:]
+		n += c
+	}
+
+	return n, nil
+}
+
+// Close encrypt the remaining data as the final frame
+// It does not close the underlying writer
+func (ew *EncryptWriter) Close() error {
+	if ew.closed {
+		return ew.err
+	}
+	ew.closed = true
+	if ew.err != nil {
+		return ew.err
+	}
+
+	ew.err = ew.writeFrame(STREAM_FLAG_FINAL)
+	return ew.err
+}
+
+// Encrypt the buffer and write it as a frame
+func (ew *EncryptWriter) writeFrame(flags byte) error {
+	chunk := make([]byte, 0, streamChunkHeaderLen+len(ew.buf))
+	chunk = append(chunk, ew.streamID...)
+	chunk = binary.BigEndian.AppendUint64(chunk, ew.seq)
+	chunk = append(chunk, flags)
+	chunk = append(chunk, ew.buf...)
+
+	encrypted, err := ew.k.EncryptContext(ew.ctx, chunk, ew.label)
+	if err != nil {
+		return err
+	}
+
+	frame := binary.BigEndian.AppendUint64(nil, ew.seq)
+	frame = append(frame, flags)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(encrypted)))
+	frame = append(frame, encrypted...)
+	if _, err := ew.w.Write(frame); err != nil {
+		return err
+	}
+
+	ew.seq++
+	ew.buf = ew.buf[:0]
+	return nil
+}
+
+// DecryptReader decrypt a stream written by EncryptWriter
+type DecryptReader struct {
+	k         *Ksema
+	ctx       context.Context
+	r         *bufio.Reader
+	label     string
+	streamID  []byte
+	chunkSize int
+	buf       []byte
+	seq       uint64
+	done      bool
+	err       error
+}
+
+// NewDecryptReader return a reader decrypting the stream from r with the key label
+// If the label is empty, the label of the stream header is used
+func (k *Ksema) NewDecryptReader(r io.Reader, label string) (*DecryptReader, error) {
+	return k.NewDecryptReaderContext(context.Background(), r, label)
+}
+
+// NewDecryptReaderContext is like NewDecryptReader but every request is bound to the context
+func (k *Ksema) NewDecryptReaderContext(ctx context.Context, r io.Reader, label string) (*DecryptReader, error) {
+	dr := &DecryptReader{
+		k:   k,
+		ctx: ctx,
+		r:   bufio.NewReader(r),
+	}
+
+	header := make([]byte, len(STREAM_MAGIC)+1+4+streamIDLen+2)
+	if _, err := io.ReadFull(dr.r, header); err != nil {
+		return nil, streamReadError(err)
+	}
+	if string(header[:len(STREAM_MAGIC)]) != STREAM_MAGIC {
+		return nil, fmt.Errorf("%w: invalid magic", ErrStreamCorrupted)
+	}
+	header = header[len(STREAM_MAGIC):]
+	if header[0] != STREAM_VERSION {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrStreamCorrupted, header[0])
+	}
+	dr.chunkSize = int(binary.BigEndian.Uint32(header[1:5]))
+	if dr.chunkSize <= 0 || dr.chunkSize > MAX_STREAM_CHUNK_SIZE {
+		return nil, fmt.Errorf("%w: invalid chunk size %d", ErrStreamCorrupted, dr.chunkSize)
+	}
+	dr.streamID = bytes.Clone(header[5 : 5+streamIDLen])
+
+	headerLabel := make([]byte, binary.BigEndian.Uint16(header[5+streamIDLen:]))
+	if _, err := io.ReadFull(dr.r, headerLabel); err != nil {
+		return nil, streamReadError(err)
+	}
+	dr.label = label
+	if dr.label == "" {
+		dr.label = string(headerLabel)
+	}
+
+	return dr, nil
+}
+
+// Read return the decrypted data
+// Return ErrStreamTruncated if the stream end before the final frame
+func (dr *DecryptReader) Read(p []byte) (int, error) {
+	for len(dr.buf) == 0 {
+		if dr.err != nil {
+			return 0, dr.err
+		}
+		if dr.done {
+			dr.err = dr.checkEnd()
+			continue
+		}
+		dr.err = dr.readFrame()
+	}
+
+	n := copy(p, dr.buf)
+	dr.buf = dr.buf[n:]
+	return n, nil
+}
+
+// Read and decrypt the next frame
+func (dr *DecryptReader) readFrame() error {
+	header := make([]byte, 8+1+4)
+	if _, err := io.ReadFull(dr.r, header); err != nil {
+		return streamReadError(err)
+	}
+
+	seq := binary.BigEndian.Uint64(header[:8])
+	flags := header[8]
+	length := int(binary.BigEndian.Uint32(header[9:]))
+	if seq != dr.seq {
+		return fmt.Errorf("%w: frame %d received instead of %d", ErrStreamCorrupted, seq, dr.seq)
+	}
+	if flags&^STREAM_FLAG_FINAL != 0 {
+		return fmt.Errorf("%w: invalid flags of frame %d", ErrStreamCorrupted, seq)
+	}
+	if length > dr.chunkSize+streamChunkHeaderLen+streamCipherOverhead {
+		return fmt.Errorf("%w: frame %d is too large", ErrStreamCorrupted, seq)
+	}
+
+	encrypted := make([]byte, length)
+	if _, err := io.ReadFull(dr.r, encrypted); err != nil {
+		return streamReadError(err)
+	}
+
+	chunk, err := dr.k.DecryptContext(dr.ctx, encrypted, dr.label)
+	if err != nil {
+		return err
+	}
+	if len(chunk) < streamChunkHeaderLen ||
+		!bytes.Equal(chunk[:streamIDLen], dr.streamID) ||
+		binary.BigEndian.Uint64(chunk[streamIDLen:]) != seq ||
+		chunk[streamIDLen+8] != flags {
+		return fmt.Errorf("%w: frame %d does not belong to the stream", ErrStreamCorrupted, seq)
+	}
+
+	dr.buf = chunk[streamChunkHeaderLen:]
+	dr.seq++
+	dr.done = flags&STREAM_FLAG_FINAL != 0
+	return nil
+}
+
+// Check that nothing follow the final frame
+func (dr *DecryptReader) checkEnd() error {
+	if _, err := dr.r.ReadByte(); err != io.EOF {
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: data after the final frame", ErrStreamCorrupted)
+	}
+	return io.EOF
+}
+
+// Turn unexpected end of the underlying reader into ErrStreamTruncated
+func streamReadError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrStreamTruncated
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+package ksema_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/suhailiealx/ksema-sdk-go"
+	"github.com/suhailiealx/ksema-sdk-go/ksematest"
+)
+
+const streamChunkSize = 100
+
+func newStreamKsema(t *testing.T) *ksema.Ksema {
+	t.Helper()
+	srv := newTestServer(t)
+
+	k := newTestClient(t, srv, ksematest.UserBrigade, ksema.WithStreamChunkSize(streamChunkSize))
+	genKey(t, k, "AES01", "")
+	return k
+}
+
+func encryptStream(t *testing.T, k *ksema.Ksema, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := k.NewEncryptWriter(&buf, "AES01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decryptStream(k *ksema.Ksema, stream []byte) ([]byte, error) {
+	r, err := k.NewDecryptReader(bytes.NewReader(stream), "")
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// Split the stream into the header and the frames
+func splitStream(t *testing.T, stream []byte) ([]byte, [][]byte) {
+	t.Helper()
+	headerLen := len(ksema.STREAM_MAGIC) + 1 + 4 + 16
+	headerLen += 2 + int(binary.BigEndian.Uint16(stream[headerLen:]))
+
+	var frames [][]byte
+	for rest := stream[headerLen:]; len(rest) > 0; {
+		frameLen := 13 + int(binary.BigEndian.Uint32(rest[9:]))
+		frames = append(frames, rest[:frameLen])
+		rest = rest[frameLen:]
+	}
+	return stream[:headerLen], frames
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	k := newStreamKsema(t)
+
+	for _, size := range []int{0, 1, streamChunkSize - 1, streamChunkSize, streamChunkSize + 1, 10*streamChunkSize + 7} {
+		data := make([]byte, size)
+		rand.Read(data)
+
+		plainText, err := decryptStream(k, encryptStream(t, k, data))
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(plainText, data) {
+			t.Fatalf("size %d: decrypted data does not match", size)
+		}
+	}
+}
+
+func TestStreamTampering(t *testing.T) {
+	k := newStreamKsema(t)
+
+	data := make([]byte, 3*streamChunkSize+10)
+	rand.Read(data)
+	stream := encryptStream(t, k, data)
+	header, frames := splitStream(t, stream)
+	if len(frames) != 4 {
+		t.Fatalf("got %d frames, want 4", len(frames))
+	}
+
+	_, otherFrames := splitStream(t, encryptStream(t, k, data))
+
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	// Frame with the sequence and flags of another position, as if reordered with a forged header
+	relabeled := bytes.Clone(frames[2])
+	binary.BigEndian.PutUint64(relabeled, 1)
+
+	tests := []struct {
+		name   string
+		stream []byte
+		want   error
+	}{
+		{"truncated inside a frame", stream[:len(stream)-5], ksema.ErrStreamTruncated},
+		{"final frame dropped", join(header, frames[0], frames[1], frames[2]), ksema.ErrStreamTruncated},
+		{"truncated header", stream[:10], ksema.ErrStreamTruncated},
+		{"frames reordered", join(header, frames[1], frames[0], frames[2], frames[3]), ksema.ErrStreamCorrupted},
+		{"frame sequence forged", join(header, frames[0], relabeled, frames[2], frames[3]), ksema.ErrStreamCorrupted},
+		{"frame of another stream", join(header, frames[0], otherFrames[1], frames[2], frames[3]), ksema.ErrStreamCorrupted},
+		{"data after the final frame", join(stream, []byte{0}), ksema.ErrStreamCorrupted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decryptStream(k, tt.stream)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package ksema
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Duration of the public keys in the cache of LocalVerifier
+const DEFAULT_VERIFIER_TTL = time.Hour
+
+// ErrKeyPinMismatch is returned when the public key of the server does not match the pinned thumbprints
+var ErrKeyPinMismatch = errors.New("public key does not match the pinned key")
+
+// LocalVerifier verify the signatures without the server using the cached public keys
+//
+// Only ECDSA key is verified locally, the data is the digest and the signature is ASN.1 DER encoded.
+// The signatures of a label are also verified by the server until one is valid for both, so the result is the one of VERIFY.
+// If the server accept a signature which is invalid locally, the label is verified by the server (see WithRemoteFallback).
+// Other key types are verified by the server too
+//
+// The public key is fetched on the first use of a label and kept for the TTL.
+// If it can not be refreshed, the expired key is used until the server is reachable again
+type LocalVerifier struct {
+	k        *Ksema
+	ttl      time.Duration
+	pins     map[string][]string
+	fallback bool
+
+	mu   sync.Mutex
+	keys map[string]*verifierKey
+}
+
+type verifierKey struct {
+	pub crypto.PublicKey
+	// The key can not be used locally, the signature is verified by the server
+	remote bool
+	// The server accepted a signature which is valid locally
+	confirmed bool
+	expires   time.Time
+}
+
+// VerifierOption configure the LocalVerifier
+type VerifierOption func(*LocalVerifier)
+
+// WithVerifierTTL set the duration of the public keys in the cache, 0 keep them forever
+// Default is 1 hour
+func WithVerifierTTL(ttl time.Duration) VerifierOption {
+	return func(v *LocalVerifier) {
+		v.ttl = ttl
+	}
+}
+
+// WithPinnedKey accept only the public keys of the label with one of the JWK thumbprints
+func WithPinnedKey(keyLabel string, thumbprints ...string) VerifierOption {
+	return func(v *LocalVerifier) {
+		v.pins[keyLabel] = append(v.pins[keyLabel], thumbprints...)
+	}
+}
+
+// WithRemoteFallback enable the verification by the server when the public key can not be used locally,
+// e.g. the key is not ECDSA, the user is not allowed to get the public key or the server does not verify the digest as is
+// Enabled by default
+func WithRemoteFallback(enabled bool) VerifierOption {
+	return func(v *LocalVerifier) {
+		v.fallback = enabled
+	}
+}
+
+// Return a verifier using the public keys of the server
+func (k *Ksema) NewLocalVerifier(opts ...VerifierOption) *LocalVerifier {
+	v := &LocalVerifier{
+		k:        k,
+		ttl:      DEFAULT_VERIFIER_TTL,
+		pins:     make(map[string][]string),
+		fallback: true,
+		keys:     make(map[string]*verifierKey),
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// Perform verify of the signature with the public key of the label
+// Return error if the signature is invalid, like Verify of Ksema
+func (v *LocalVerifier) Verify(data, signature []byte, keyLabel string) error {
+	return v.VerifyContext(context.Background(), data, signature, keyLabel)
+}
+
+// VerifyContext is like Verify but bound to the context
+func (v *LocalVerifier) VerifyContext(ctx context.Context, data, signature []byte, keyLabel string) error {
+	key, err := v.publicKey(ctx, keyLabel)
+	if err != nil {
+		return err
+	}
+	v.mu.Lock()
+	remote, confirmed := key.remote, key.confirmed
+	v.mu.Unlock()
+	if remote {
+		return v.k.VerifyContext(ctx, data, signature, keyLabel)
+	}
+
+	valid := verifySignature(key.pub, data, signature)
+	if !confirmed {
+		return v.confirm(ctx, key, valid, data, signature, keyLabel)
+	}
+	if !valid {
+		return &Error{
+			Op:       FunctionVerify,
+			Label:    keyLabel,
+			RetCode:  FAILED,
+			ErrorMsg: "invalid signature",
+		}
+	}
+	return nil
+}
+
+// Verify the signature by the server and compare with the local result
+// A signature valid for both confirm the key, the next signatures are only verified locally
+func (v *LocalVerifier) confirm(ctx context.Context, key *verifierKey, valid bool, data, signature []byte, keyLabel string) error {
+	if err := v.k.VerifyContext(ctx, data, signature, keyLabel); err != nil {
+		return err
+	}
+	if valid {
+		v.mu.Lock()
+		key.confirmed = true
+		v.mu.Unlock()
+		return nil
+	}
+
+	// The server does not verify the digest as is
+	if !v.fallback {
+		return fmt.Errorf("%w: signature accepted by the server is invalid locally", ErrUnsupportedSignature)
+	}
+	v.mu.Lock()
+	key.remote = true
+	v.mu.Unlock()
+	return nil
+}
+
+// Remove the public key of the label from the cache, or every key if the label is empty
+func (v *LocalVerifier) Invalidate(keyLabel string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if keyLabel == "" {
+		clear(v.keys)
+		return
+	}
+	delete(v.keys, keyLabel)
+}
+
+// Return the cached public key of the label or fetch it from the server
+func (v *LocalVerifier) publicKey(ctx context.Context, keyLabel string) (*verifierKey, error) {
+	v.mu.Lock()
+	cached := v.keys[keyLabel]
+	v.mu.Unlock()
+	if cached != nil && (v.ttl <= 0 || time.Now().Before(cached.expires)) {
+		return cached, nil
+	}
+
+	key, err := v.fetch(ctx, keyLabel)
+	if err != nil {
+		// Keep using the expired key while the server is unreachable
+		if cached != nil && (isConnectionError(err) || errors.Is(err, ErrCircuitOpen)) {
+			return cached, nil
+		}
+		return nil, err
+	}
+
+	v.mu.Lock()
+	// The refreshed key is the same, keep what is known of the server
+	if cached != nil && sameKey(cached.pub, key.pub) {
+		key.confirmed = cached.confirmed
+		key.remote = key.remote || cached.remote
+	}
+	v.keys[keyLabel] = key
+	v.mu.Unlock()
+	return key, nil
+}
+
+// Fetch the public key of the label and check the pins
+func (v *LocalVerifier) fetch(ctx context.Context, keyLabel string) (*verifierKey, error) {
+	key := &verifierKey{expires: time.Now().Add(v.ttl)}
+
+	pub, err := v.k.PublicKeyContext(ctx, keyLabel)
+	if err != nil {
+		if v.fallback && errors.Is(err, ErrUnauthorized) {
+			key.remote = true
+			return key, nil
+		}
+		return nil, err
+	}
+
+	if pins, ok := v.pins[keyLabel]; ok {
+		jwk, err := PublicKeyJWK(pub)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrKeyPinMismatch, err)
+		}
+		if !slices.Contains(pins, jwk.Kid) {
+			return nil, fmt.Errorf("%w: label %q has thumbprint %s", ErrKeyPinMismatch, keyLabel, jwk.Kid)
+		}
+	}
+
+	switch pub.(type) {
+	case *ecdsa.PublicKey:
+		key.pub = pub
+	default:
+		if !v.fallback {
+			return nil, fmt.Errorf("%w: public key type %T", ErrUnsupportedSignature, pub)
+		}
+		key.remote = true
+	}
+	return key, nil
+}
+
+func sameKey(a, b crypto.PublicKey) bool {
+	ecA, ok := a.(*ecdsa.PublicKey)
+	return ok && ecA.Equal(b)
+}
+
+func verifySignature(pub crypto.PublicKey, data, signature []byte) bool {
+	ecPub, ok := pub.(*ecdsa.PublicKey)
+	return ok && ecdsa.VerifyASN1(ecPub, data, signature)
+}
@@ -0,0 +1,153 @@
+package ksema_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/suhailiealx/ksema-sdk-go"
+	"github.com/suhailiealx/ksema-sdk-go/ksematest"
+)
+
+func TestLocalVerifier(t *testing.T) {
+	srv := newTestServer(t)
+
+	k := newTestClient(t, srv, ksematest.UserBrigade)
+	genKey(t, k, "PUB01", "PRIV01")
+
+	digest := sha256.Sum256([]byte("message"))
+	signature, err := k.Sign(digest[:], "PRIV01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub, err := k.PublicKey("PUB01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := ksema.PublicKeyJWK(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := k.NewLocalVerifier(
+		ksema.WithPinnedKey("PUB01", jwk.Kid),
+		ksema.WithVerifierTTL(time.Millisecond),
+	)
+	if err := verifier.Verify(digest[:], signature, "PUB01"); err != nil {
+		t.Fatal(err)
+	}
+
+	other := sha256.Sum256([]byte("other message"))
+	if err := verifier.Verify(other[:], signature, "PUB01"); !errors.Is(err, ksema.ErrFailed) {
+		t.Fatalf("invalid signature: got %v, want ErrFailed", err)
+	}
+
+	// The expired key is used while the server is unreachable
+	srv.Close()
+	time.Sleep(5 * time.Millisecond)
+	if err := verifier.Verify(digest[:], signature, "PUB01"); err != nil {
+		t.Fatalf("verify with the expired key: %v", err)
+	}
+}
+
+func TestLocalVerifierPin(t *testing.T) {
+	srv := newTestServer(t)
+
+	k := newTestClient(t, srv, ksematest.UserBrigade)
+	genKey(t, k, "PUB01", "PRIV01")
+
+	digest := sha256.Sum256([]byte("message"))
+	signature, err := k.Sign(digest[:], "PRIV01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := k.NewLocalVerifier(ksema.WithPinnedKey("PUB01", "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"))
+	if err := verifier.Verify(digest[:], signature, "PUB01"); !errors.Is(err, ksema.ErrKeyPinMismatch) {
+		t.Fatalf("got %v, want ErrKeyPinMismatch", err)
+	}
+}
+
+// Return a client counting its VERIFY requests, the server hash the data before signing and verifying if hashing is set
+func newVerifierKsema(t *testing.T, hashing bool) (*ksema.Ksema, *atomic.Int32) {
+	t.Helper()
+	srv := newTestServer(t)
+
+	verifies := new(atomic.Int32)
+	intercept := func(ctx context.Context, req *ksema.ServiceRequest, invoke ksema.Invoker) (*ksema.ServiceResponse, error) {
+		if req.Operation == ksema.FunctionVerify {
+			verifies.Add(1)
+		}
+		if hashing && req.Operation == ksema.FunctionSign {
+			digest := sha256.Sum256(req.Data)
+			req.Data = digest[:]
+		}
+		if hashing && req.Operation == ksema.FunctionVerify {
+			// Data and signature prefixed by their length
+			dataLen := int(binary.BigEndian.Uint16(req.Data))
+			digest := sha256.Sum256(req.Data[2 : 2+dataLen])
+			req.Data = slices.Concat(binary.BigEndian.AppendUint16(nil, uint16(len(digest))), digest[:], req.Data[2+dataLen:])
+		}
+		return invoke(ctx, req)
+	}
+	k := newTestClient(t, srv, ksematest.UserBrigade, ksema.WithInterceptors(intercept))
+	genKey(t, k, "PUB01", "PRIV01")
+	return k, verifies
+}
+
+func TestLocalVerifierConfirm(t *testing.T) {
+	k, verifies := newVerifierKsema(t, false)
+	verifier := k.NewLocalVerifier()
+
+	digest := sha256.Sum256([]byte("message"))
+	signature, err := k.Sign(digest[:], "PRIV01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An invalid signature does not confirm the key
+	other := sha256.Sum256([]byte("other message"))
+	if err := verifier.Verify(other[:], signature, "PUB01"); !errors.Is(err, ksema.ErrFailed) {
+		t.Fatalf("invalid signature: got %v, want ErrFailed", err)
+	}
+	for range 3 {
+		if err := verifier.Verify(digest[:], signature, "PUB01"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := verifies.Load(); n != 2 {
+		t.Fatalf("got %d verify requests, want 2", n)
+	}
+}
+
+func TestLocalVerifierHashingServer(t *testing.T) {
+	k, verifies := newVerifierKsema(t, true)
+
+	digest := sha256.Sum256([]byte("message"))
+	signature, err := k.Sign(digest[:], "PRIV01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The result is the one of the server
+	verifier := k.NewLocalVerifier()
+	for range 3 {
+		if err := verifier.Verify(digest[:], signature, "PUB01"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := verifies.Load(); n != 3 {
+		t.Fatalf("got %d verify requests, want 3", n)
+	}
+
+	verifier = k.NewLocalVerifier(ksema.WithRemoteFallback(false))
+	if err := verifier.Verify(digest[:], signature, "PUB01"); !errors.Is(err, ksema.ErrUnsupportedSignature) {
+		t.Fatalf("without fallback: got %v, want ErrUnsupportedSignature", err)
+	}
+}